@@ -23,7 +23,9 @@
 package ants
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"math"
 	"os"
@@ -66,6 +68,36 @@ var (
 	// ErrTimeout will be returned after the operations timed out.
 	ErrTimeout = errors.New("operation timed out")
 
+	// ErrWouldDropWork will be returned when shrinking a pool below the number of its busy workers.
+	ErrWouldDropWork = errors.New("can not shrink the capacity below the number of busy workers")
+
+	// ErrDrainToSelf will be returned when draining a pool to itself.
+	ErrDrainToSelf = errors.New("can not drain a pool to itself")
+
+	// ErrSessionClosed will be returned when running a task in a closed session.
+	ErrSessionClosed = errors.New("this session has been closed")
+
+	// ErrMemoryBudgetExceeded will be returned when the estimated memory of a task doesn't fit in the memory budget.
+	ErrMemoryBudgetExceeded = errors.New("estimated memory of in-flight tasks exceeds the budget")
+
+	// ErrInvalidStats will be returned when decoding the stats from a buffer that's not encoded by AppendStats.
+	ErrInvalidStats = errors.New("invalid encoding of the pool stats")
+
+	// ErrInsufficientHeadroom will be returned when submitting a task would leave fewer available workers than reserved.
+	ErrInsufficientHeadroom = errors.New("too few workers would be left available for the reserve")
+
+	// ErrPoolQuiesced will be returned when submitting a task to a pool being quiesced.
+	ErrPoolQuiesced = errors.New("this pool is being quiesced")
+
+	// ErrCircuitOpen will be returned when submitting a task while the circuit breaker of the pool is open.
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+
+	// ErrBroadcastFromTask will be returned when calling Pool.Broadcast() from a task running on a worker.
+	ErrBroadcastFromTask = errors.New("can not broadcast from a task running on a worker")
+
+	// ErrSynchronousSession will be returned when calling Pool.NewSession() on a pool with Synchronous set.
+	ErrSynchronousSession = errors.New("can not start a session without workers under Synchronous")
+
 	// workerChanCap determines whether the channel of a worker should be a buffered channel
 	// to get the best performance. Inspired by fasthttp at
 	// https://github.com/valyala/fasthttp/blob/master/workerpool.go#L139
@@ -92,12 +124,229 @@ var (
 
 const nowTimeUpdateInterval = 500 * time.Millisecond
 
+// dynamicCapacityTTL is how long the capacity returned by Options.DynamicCapacity is cached.
+const dynamicCapacityTTL = 100 * time.Millisecond
+
+// PanicError is the error converted from a panic of a task, along with the stack trace where it panicked.
+type PanicError struct {
+	// Value is the value recovered from the panic.
+	Value interface{}
+
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task panicked: %v\n%s", e.Value, e.Stack)
+}
+
+// PoolMemoryPressureError is the error returned when submitting a task while the heap of the process
+// exceeds Options.MemoryPressureLimit.
+type PoolMemoryPressureError struct {
+	// HeapAlloc is the heap usage last sampled, in bytes.
+	HeapAlloc uint64
+
+	// Limit is the high-water threshold of the heap usage, in bytes.
+	Limit uint64
+}
+
+func (e *PoolMemoryPressureError) Error() string {
+	return fmt.Sprintf("heap usage %d bytes exceeds the memory pressure limit %d bytes", e.HeapAlloc, e.Limit)
+}
+
+// PoolEvent is a transition event of a pool emitted through Pool.Events.
+type PoolEvent int
+
+const (
+	// TaskSubmitted is emitted when a task is accepted to run.
+	TaskSubmitted PoolEvent = iota
+
+	// TaskCompleted is emitted when a task completes.
+	TaskCompleted
+
+	// WorkerSpawned is emitted when a worker goroutine is started.
+	WorkerSpawned
+
+	// WorkerStopped is emitted when a worker goroutine exits.
+	WorkerStopped
+
+	// PoolReleased is emitted when the pool is released.
+	PoolReleased
+)
+
+func (e PoolEvent) String() string {
+	switch e {
+	case TaskSubmitted:
+		return "TaskSubmitted"
+	case TaskCompleted:
+		return "TaskCompleted"
+	case WorkerSpawned:
+		return "WorkerSpawned"
+	case WorkerStopped:
+		return "WorkerStopped"
+	case PoolReleased:
+		return "PoolReleased"
+	}
+	return fmt.Sprintf("PoolEvent(%d)", int(e))
+}
+
+// AdmissionResult is how a submission was handled by a pool, as reported by Pool.SubmitAdmission.
+type AdmissionResult int
+
+const (
+	// Admitted means that the task was dispatched to a worker at once.
+	Admitted AdmissionResult = iota
+
+	// Queued means that the task was dispatched after waiting in the queue for a worker.
+	Queued
+
+	// Blocked means that the submission waited in the queue but the task was never dispatched,
+	// e.g. the pool was closed in the meantime.
+	Blocked
+
+	// Rejected means that the task was refused without waiting, e.g. the pool was closed or overloaded.
+	Rejected
+)
+
+func (r AdmissionResult) String() string {
+	switch r {
+	case Admitted:
+		return "Admitted"
+	case Queued:
+		return "Queued"
+	case Blocked:
+		return "Blocked"
+	case Rejected:
+		return "Rejected"
+	}
+	return fmt.Sprintf("AdmissionResult(%d)", int(r))
+}
+
+// Trace is the timing breakdown of a task submitted by Pool.SubmitTraced.
+type Trace struct {
+	// QueuedAt is when the task was submitted.
+	QueuedAt time.Time
+
+	// StartedAt is when a worker started running the task.
+	StartedAt time.Time
+
+	// FinishedAt is when the task completed.
+	FinishedAt time.Time
+}
+
+// WorkerInfo is a read-only snapshot of a worker reported by Pool.ForEachWorker.
+type WorkerInfo struct {
+	// ID identifies the worker within its pool.
+	ID uint64
+
+	// Busy indicates whether the worker is running a task, otherwise it's idle.
+	Busy bool
+
+	// Tag is the tag of the running task if it's submitted by Pool.SubmitTagged.
+	Tag string
+
+	// Age is how long ago the worker was spawned.
+	Age time.Duration
+
+	// Served is the number of tasks the worker has run.
+	Served uint64
+}
+
+// BackoffPolicy is how Pool.SubmitBackoff retries a failing task.
+type BackoffPolicy struct {
+	// MaxAttempts is the maximum number of times the task is run, including the first one.
+	MaxAttempts int
+
+	// InitialDelay is the delay before the first retry.
+	InitialDelay time.Duration
+
+	// Multiplier scales the delay after each retry, the delay doubles if it's not greater than 1.
+	Multiplier float64
+
+	// MaxDelay caps the delay between the retries, 0 means no cap.
+	MaxDelay time.Duration
+}
+
+func (b BackoffPolicy) next(delay time.Duration) time.Duration {
+	multiplier := b.Multiplier
+	if multiplier <= 1 {
+		multiplier = 2
+	}
+	delay = time.Duration(float64(delay) * multiplier)
+	if b.MaxDelay > 0 && delay > b.MaxDelay {
+		delay = b.MaxDelay
+	}
+	return delay
+}
+
+// saturationAlert tracks how long a pool stays saturated to call Options.SaturationAlert,
+// it's only accessed by the ticktock goroutine of the pool.
+type saturationAlert struct {
+	since   time.Time
+	alerted bool
+}
+
+func (a *saturationAlert) observe(now time.Time, saturated bool, opts *Options) {
+	if !saturated {
+		a.since, a.alerted = time.Time{}, false
+		return
+	}
+	if a.since.IsZero() {
+		a.since = now
+	}
+	if !a.alerted && now.Sub(a.since) >= opts.SaturationAlertDuration {
+		a.alerted = true
+		opts.SaturationAlert()
+	}
+}
+
+// userData wraps the data attached to a pool, since atomic.Value can't store nil or values of different types.
+type userData struct {
+	value interface{}
+}
+
 // Logger is used for logging formatted messages.
 type Logger interface {
 	// Printf must have the same semantics as log.Printf.
 	Printf(format string, args ...interface{})
 }
 
+// Admitter controls the admission of tasks with custom semantics, e.g. a semaphore, a rate limiter or a weighted scheme.
+type Admitter interface {
+	// Acquire blocks until a task is admitted, or returns an error to reject it, ctx is the context
+	// of the submission, e.g. of Pool.SubmitWithContext(), which is Background() by default.
+	Acquire(ctx context.Context) error
+
+	// Release is called after an admitted task completes or fails to be dispatched.
+	Release()
+}
+
+// CircuitBreaker protects a failing downstream from the tasks submitted to a pool, e.g. by rejecting them
+// after too many consecutive failures until a cool-down elapses.
+type CircuitBreaker interface {
+	// Allow reports whether a task may be submitted, the submission is rejected with ErrCircuitOpen otherwise.
+	Allow() bool
+
+	// Report is called with the outcome of every task submitted by an error-returning submission.
+	Report(success bool)
+}
+
+// TryAdmitter is an Admitter which can also admit a task without blocking. The submissions which never block,
+// such as Pool.SubmitIfAvailable(), pass an already-done context to Acquire, so an Admitter that would
+// otherwise block regardless of the context should implement TryAdmitter.
+type TryAdmitter interface {
+	Admitter
+
+	// TryAcquire reports whether a task is admitted at once, the task is rejected with ErrPoolOverload otherwise.
+	TryAcquire() bool
+}
+
+// Job is a task object carrying its own state, it can be submitted by Pool.SubmitJob.
+type Job interface {
+	// Run performs the job on a worker.
+	Run()
+}
+
 // Submit submits a task to pool.
 func Submit(task func()) error {
 	return defaultAntsPool.Submit(task)
@@ -132,3 +381,42 @@ func ReleaseTimeout(timeout time.Duration) error {
 func Reboot() {
 	defaultAntsPool.Reboot()
 }
+
+// SetDefaultPanicHandler sets up the PanicHandler of the default pool, so that the tasks submitted by Submit
+// can be guarded without constructing a dedicated pool. It's meant to be called before the default pool is used.
+func SetDefaultPanicHandler(handler func(interface{})) {
+	_ = defaultAntsPool.Reconfigure(func(opts *Options) {
+		opts.PanicHandler = handler
+	})
+}
+
+// SetDefaultPoolSize changes the capacity of the default pool like Pool.Tune, which is DefaultAntsPoolSize initially.
+// It's meant to be called before the default pool is used.
+func SetDefaultPoolSize(size int) {
+	defaultAntsPool.Tune(size)
+}
+
+// ReleaseInOrder releases the given pools one by one, waiting for all workers of each pool to exit
+// before moving on to the next, so that the upstream stages of a pipeline stop before the downstream ones.
+// It returns ErrPoolClosed without releasing any pool if one of them has been closed, or ErrTimeout
+// if the workers haven't all exited within the timeout, leaving the pools after the current one open.
+func ReleaseInOrder(timeout time.Duration, pools ...*Pool) error {
+	for _, p := range pools {
+		if p.IsClosed() {
+			return ErrPoolClosed
+		}
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for _, p := range pools {
+		p.Release()
+		for p.Running() > 0 {
+			select {
+			case <-p.stopped:
+			case <-timer.C:
+				return ErrTimeout
+			}
+		}
+	}
+	return nil
+}
@@ -165,3 +165,163 @@ func BenchmarkAntsPoolThroughput(b *testing.B) {
 		}
 	}
 }
+
+func benchmarkParallelSubmit(b *testing.B, p *Pool) {
+	var wg sync.WaitGroup
+	task := func() {
+		wg.Done()
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			wg.Add(1)
+			_ = p.Submit(task)
+		}
+	})
+	wg.Wait()
+}
+
+// BenchmarkAntsPoolParallelSubmit measures the contention on the worker queue with many concurrent submitters.
+func BenchmarkAntsPoolParallelSubmit(b *testing.B) {
+	p, _ := NewPool(runtime.NumCPU(), WithExpiryDuration(DefaultExpiredTime))
+	defer p.Release()
+	benchmarkParallelSubmit(b, p)
+}
+
+// BenchmarkAntsPoolParallelSubmitWithMutex is BenchmarkAntsPoolParallelSubmit with the worker queue
+// guarded by sync.Mutex instead of the spin-lock, as a baseline.
+func BenchmarkAntsPoolParallelSubmitWithMutex(b *testing.B) {
+	p, _ := NewPool(runtime.NumCPU(), WithExpiryDuration(DefaultExpiredTime))
+	defer p.Release()
+	p.lock = new(sync.Mutex)
+	p.cond = sync.NewCond(p.lock)
+	benchmarkParallelSubmit(b, p)
+}
+
+func benchmarkSingleProducerSubmit(b *testing.B, p *Pool) {
+	var wg sync.WaitGroup
+	task := func() {
+		wg.Done()
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		_ = p.Submit(task)
+	}
+	wg.Wait()
+}
+
+// BenchmarkAntsPoolSingleProducerSubmit measures the submissions of a single producer, where the spin-lock
+// is taken by a single CAS on the fast path without ever parking the producer.
+func BenchmarkAntsPoolSingleProducerSubmit(b *testing.B) {
+	p, _ := NewPool(runtime.NumCPU(), WithExpiryDuration(DefaultExpiredTime))
+	defer p.Release()
+	benchmarkSingleProducerSubmit(b, p)
+}
+
+// BenchmarkAntsPoolSingleProducerSubmitWithMutex is BenchmarkAntsPoolSingleProducerSubmit with the worker queue
+// guarded by sync.Mutex instead of the spin-lock, as a baseline.
+func BenchmarkAntsPoolSingleProducerSubmitWithMutex(b *testing.B) {
+	p, _ := NewPool(runtime.NumCPU(), WithExpiryDuration(DefaultExpiredTime))
+	defer p.Release()
+	p.lock = new(sync.Mutex)
+	p.cond = sync.NewCond(p.lock)
+	benchmarkSingleProducerSubmit(b, p)
+}
+
+func benchmarkColdRamp(b *testing.B, options ...Option) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		p, _ := NewPool(PoolCap, options...)
+		wg.Add(PoolCap)
+		for j := 0; j < PoolCap; j++ {
+			_ = p.Submit(wg.Done)
+		}
+		wg.Wait()
+		p.Release()
+	}
+}
+
+// BenchmarkAntsPoolColdRamp measures a cold pool ramping up to its full capacity.
+func BenchmarkAntsPoolColdRamp(b *testing.B) {
+	benchmarkColdRamp(b)
+}
+
+// BenchmarkAntsPoolColdRampWithInitialWorkerCap is BenchmarkAntsPoolColdRamp with a pre-sized worker queue.
+func BenchmarkAntsPoolColdRampWithInitialWorkerCap(b *testing.B) {
+	benchmarkColdRamp(b, WithInitialWorkerCap(PoolCap))
+}
+
+func benchmarkManyPools(b *testing.B, newPool func() (*Pool, error)) {
+	const pools = 100
+	base := runtime.NumGoroutine()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ps := make([]*Pool, pools)
+		for j := range ps {
+			ps[j], _ = newPool()
+		}
+		b.ReportMetric(float64(runtime.NumGoroutine()-base), "goroutines")
+		for _, p := range ps {
+			_ = p.ReleaseTimeout(time.Second)
+		}
+	}
+}
+
+// BenchmarkManyIndependentPools measures the background goroutines of many small pools with their own janitors.
+func BenchmarkManyIndependentPools(b *testing.B) {
+	benchmarkManyPools(b, func() (*Pool, error) {
+		return NewPool(4)
+	})
+}
+
+// BenchmarkManyManagedPools is BenchmarkManyIndependentPools with a PoolManager sharing one goroutine.
+func BenchmarkManyManagedPools(b *testing.B) {
+	m := NewPoolManager()
+	defer m.Close()
+	benchmarkManyPools(b, func() (*Pool, error) {
+		return m.NewPool(4)
+	})
+}
+
+type invokeArg struct {
+	id, n int
+}
+
+func benchmarkInvoke(b *testing.B, arg func(i int) interface{}) {
+	var wg sync.WaitGroup
+	p, _ := NewPoolWithFunc(runtime.NumCPU(), func(interface{}) {
+		wg.Done()
+	}, WithExpiryDuration(DefaultExpiredTime))
+	defer p.Release()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		_ = p.Invoke(arg(i))
+	}
+	wg.Wait()
+}
+
+// BenchmarkPoolWithFuncInvokeValue measures PoolWithFunc.Invoke with a struct argument, which is boxed on every call.
+func BenchmarkPoolWithFuncInvokeValue(b *testing.B) {
+	benchmarkInvoke(b, func(i int) interface{} {
+		return invokeArg{id: i, n: i}
+	})
+}
+
+// BenchmarkPoolWithFuncInvokePointer is BenchmarkPoolWithFuncInvokeValue with pointers to the preallocated arguments,
+// which fit in the interface without boxing.
+func BenchmarkPoolWithFuncInvokePointer(b *testing.B) {
+	args := make([]invokeArg, b.N)
+	benchmarkInvoke(b, func(i int) interface{} {
+		args[i] = invokeArg{id: i, n: i}
+		return &args[i]
+	})
+}
@@ -23,9 +23,14 @@
 package ants
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -519,6 +524,26 @@ func TestRebootDefaultPool(t *testing.T) {
 	wg.Wait()
 }
 
+func TestConfigureDefaultPool(t *testing.T) {
+	defer Release()
+	Reboot()
+
+	SetDefaultPoolSize(8)
+	defer SetDefaultPoolSize(DefaultAntsPoolSize)
+	assert.EqualValues(t, 8, Cap(), "the default pool should be resized")
+
+	panics := make(chan interface{}, 1)
+	SetDefaultPanicHandler(func(p interface{}) { panics <- p })
+	defer SetDefaultPanicHandler(nil)
+	assert.NoError(t, Submit(func() { panic("default") }))
+	select {
+	case p := <-panics:
+		assert.Equal(t, "default", p, "the panic handler of the default pool should fire")
+	case <-time.After(time.Second):
+		t.Fatal("the panic handler of the default pool should fire")
+	}
+}
+
 func TestRebootNewPool(t *testing.T) {
 	var wg sync.WaitGroup
 	p, err := NewPool(10)
@@ -974,6 +999,29 @@ func TestReleaseTimeout(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestReleaseTimeoutForceStop(t *testing.T) {
+	p, _ := NewPool(10)
+	block := make(chan struct{})
+	assert.NoError(t, p.Submit(func() { <-block }))
+	assert.NoError(t, p.Submit(func() {}))
+	assert.EqualError(t, p.ReleaseTimeout(100*time.Millisecond), ErrTimeout.Error(),
+		"the task outliving the grace period should be reported")
+	assert.True(t, p.IsClosed())
+	assert.EqualError(t, p.Submit(func() {}), ErrPoolClosed.Error())
+	assert.EqualValues(t, 1, p.Running(), "only the worker of the running task should be left")
+
+	// The abandoned worker exits once its task returns.
+	close(block)
+	assert.Eventually(t, func() bool { return p.Running() == 0 }, time.Second, time.Millisecond)
+
+	pf, _ := NewPoolWithFunc(10, longRunningPoolFunc)
+	pfch := make(chan struct{})
+	assert.NoError(t, pf.Invoke(pfch))
+	assert.EqualError(t, pf.ReleaseTimeout(100*time.Millisecond), ErrTimeout.Error())
+	close(pfch)
+	assert.Eventually(t, func() bool { return pf.Running() == 0 }, time.Second, time.Millisecond)
+}
+
 func TestDefaultPoolReleaseTimeout(t *testing.T) {
 	Reboot()
 	for i := 0; i < 5; i++ {
@@ -985,3 +1033,3060 @@ func TestDefaultPoolReleaseTimeout(t *testing.T) {
 	err := ReleaseTimeout(2 * time.Second)
 	assert.NoError(t, err)
 }
+
+func TestMemoryGuard(t *testing.T) {
+	estimator := func(func()) uint64 { return 60 }
+	p, _ := NewPool(10, WithMemoryGuard(100, estimator))
+	defer p.Release()
+
+	ch := make(chan struct{})
+	done := make(chan struct{})
+	assert.NoError(t, p.Submit(func() {
+		<-ch
+		close(done)
+	}), "submit within the memory budget should succeed")
+	assert.EqualError(t, p.Submit(demoFunc), ErrMemoryBudgetExceeded.Error(),
+		"submit beyond the memory budget should be rejected")
+
+	close(ch)
+	<-done
+	// The memory is given back right after the task returns, wait for the worker to finish the accounting.
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, p.Submit(demoFunc), "submit should succeed after the in-flight task completed")
+}
+
+func TestClonePool(t *testing.T) {
+	var panicCounter int64
+	var wg sync.WaitGroup
+	p, _ := NewPool(10, WithNonblocking(true), WithPanicHandler(func(interface{}) {
+		defer wg.Done()
+		atomic.AddInt64(&panicCounter, 1)
+	}))
+	defer p.Release()
+
+	cp, err := p.Clone()
+	assert.NoErrorf(t, err, "clone pool failed: %v", err)
+	defer cp.Release()
+	assert.EqualValues(t, p.Cap(), cp.Cap(), "cloned pool should have the same capacity")
+
+	wg.Add(1)
+	assert.NoError(t, cp.Submit(func() {
+		panic("Oops!")
+	}))
+	wg.Wait()
+	assert.EqualValues(t, 1, atomic.LoadInt64(&panicCounter), "cloned pool should keep the panic handler")
+
+	ch := make(chan struct{})
+	for i := 0; i < cp.Cap(); i++ {
+		assert.NoError(t, cp.Submit(func() { <-ch }))
+	}
+	assert.EqualError(t, cp.Submit(demoFunc), ErrPoolOverload.Error(), "cloned pool should be nonblocking")
+	assert.EqualValues(t, 0, p.Running(), "cloned pool should not share workers with the original one")
+	close(ch)
+
+	pf, _ := NewPoolWithFunc(10, demoPoolFunc, WithExpiryDuration(time.Minute))
+	defer pf.Release()
+	cpf, err := pf.Clone()
+	assert.NoErrorf(t, err, "clone pool with func failed: %v", err)
+	defer cpf.Release()
+	assert.EqualValues(t, pf.Cap(), cpf.Cap(), "cloned pool should have the same capacity")
+	assert.EqualValues(t, time.Minute, cpf.options.ExpiryDuration, "cloned pool should keep the expiry duration")
+	assert.NoError(t, cpf.Invoke(Param))
+}
+
+func TestSubmitReentrant(t *testing.T) {
+	p, _ := NewPool(2)
+	defer p.Release()
+
+	var sum int64
+	var wg sync.WaitGroup
+	var divide func(lo, hi int64)
+	divide = func(lo, hi int64) {
+		defer wg.Done()
+		if hi-lo <= 8 {
+			for i := lo; i < hi; i++ {
+				atomic.AddInt64(&sum, i)
+			}
+			return
+		}
+		mid := (lo + hi) / 2
+		wg.Add(2)
+		_ = p.SubmitReentrant(func() { divide(lo, mid) })
+		_ = p.SubmitReentrant(func() { divide(mid, hi) })
+	}
+
+	done := make(chan struct{})
+	wg.Add(1)
+	assert.NoError(t, p.SubmitReentrant(func() { divide(0, 1024) }))
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("re-entrant submission got deadlocked")
+	}
+	assert.EqualValues(t, 1023*1024/2, atomic.LoadInt64(&sum))
+	assert.LessOrEqual(t, p.Running(), p.Cap(), "re-entrant submission should not exceed the capacity")
+
+	p.Release()
+	assert.EqualError(t, p.SubmitReentrant(demoFunc), ErrPoolClosed.Error(), "pool should be closed")
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	p, _ := NewPool(10)
+	assert.Nil(t, p.LatencyHistogram(), "latency histogram should be nil if tracking is disabled")
+	p.Release()
+
+	p, _ = NewPool(10, WithLatencyTracking(true))
+	for i := 0; i < 3; i++ {
+		_ = p.Submit(func() { time.Sleep(15 * time.Millisecond) })
+	}
+	for i := 0; i < 2; i++ {
+		_ = p.Submit(func() { time.Sleep(60 * time.Millisecond) })
+	}
+	assert.NoError(t, p.ReleaseTimeout(time.Second))
+	h := p.LatencyHistogram()
+	assert.EqualValues(t, 3, h[25*time.Millisecond], "tasks should land in the 25ms bucket")
+	assert.EqualValues(t, 2, h[100*time.Millisecond], "tasks should land in the 100ms bucket")
+	assert.EqualValues(t, 0, h[time.Millisecond], "no task should land in the 1ms bucket")
+
+	pf, _ := NewPoolWithFunc(10, demoPoolFunc, WithLatencyTracking(true))
+	for i := 0; i < 3; i++ {
+		_ = pf.Invoke(15)
+	}
+	assert.NoError(t, pf.ReleaseTimeout(time.Second))
+	assert.EqualValues(t, 3, pf.LatencyHistogram()[25*time.Millisecond], "tasks should land in the 25ms bucket")
+}
+
+func TestTotalExecTime(t *testing.T) {
+	p, _ := NewPool(10)
+	assert.NoError(t, p.Submit(func() { time.Sleep(time.Millisecond) }))
+	assert.NoError(t, p.ReleaseTimeout(time.Second))
+	assert.Zero(t, p.TotalExecTime(), "the clock should not be read by default")
+
+	p, _ = NewPool(10, WithExecTimeTracking(true))
+	for i := 0; i < 5; i++ {
+		_ = p.Submit(func() { time.Sleep(20 * time.Millisecond) })
+	}
+	assert.NoError(t, p.ReleaseTimeout(time.Second))
+	total := p.TotalExecTime()
+	assert.GreaterOrEqual(t, int64(total), int64(100*time.Millisecond), "the total should include every task")
+	assert.Less(t, int64(total), int64(500*time.Millisecond), "the total should approximate the sum of the sleeps")
+
+	assert.Nil(t, p.LatencyHistogram(), "the histogram should be kept only under LatencyTracking")
+
+	pf, _ := NewPoolWithFunc(10, demoPoolFunc, WithLatencyTracking(true))
+	for i := 0; i < 3; i++ {
+		_ = pf.Invoke(20)
+	}
+	assert.NoError(t, pf.ReleaseTimeout(time.Second))
+	assert.GreaterOrEqual(t, int64(pf.TotalExecTime()), int64(60*time.Millisecond))
+}
+
+func TestShutdownNow(t *testing.T) {
+	// The memory guard makes sure that the returned tasks aren't wrapped with the reservations of the pool.
+	p, _ := NewPool(1, WithMemoryGuard(1<<20, func(func()) uint64 { return 10 }))
+	ch := make(chan struct{})
+	_ = p.Submit(func() { <-ch })
+
+	var wg sync.WaitGroup
+	var started int32
+	marks := make([]int32, 3)
+	errs := make(chan error, len(marks))
+	for i := range marks {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- p.Submit(func() {
+				atomic.AddInt32(&started, 1)
+				atomic.StoreInt32(&marks[i], 1)
+			})
+		}()
+	}
+	for p.Waiting() < len(marks) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tasks := p.ShutdownNow()
+	assert.True(t, p.IsClosed(), "pool should be closed after ShutdownNow")
+	assert.Len(t, tasks, len(marks), "ShutdownNow should return all the unstarted tasks")
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.EqualError(t, err, ErrPoolClosed.Error(), "blocked submit should get an ErrPoolClosed")
+	}
+	close(ch)
+	time.Sleep(10 * time.Millisecond)
+	assert.EqualValues(t, 0, atomic.LoadInt32(&started), "unstarted tasks should never run on the pool")
+	assert.Eventually(t, func() bool { return atomic.LoadUint64(&p.inflightBytes) == 0 }, time.Second, time.Millisecond)
+
+	for _, task := range tasks {
+		task()
+	}
+	for i := range marks {
+		assert.EqualValuesf(t, 1, marks[i], "task %d should be returned by ShutdownNow", i)
+	}
+	assert.EqualValues(t, 0, atomic.LoadUint64(&p.inflightBytes), "the returned tasks should be the submitted ones")
+	assert.Nil(t, p.ShutdownNow(), "ShutdownNow on a closed pool should return nothing")
+}
+
+func TestSubmitTimed(t *testing.T) {
+	p, _ := NewPool(1)
+	defer p.Release()
+
+	waited, err := p.SubmitTimed(func() {
+		time.Sleep(100 * time.Millisecond)
+	})
+	assert.NoError(t, err)
+	assert.Zero(t, waited, "submit should not wait when there is an available worker")
+
+	waited, err = p.SubmitTimed(demoFunc)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, int64(waited), int64(50*time.Millisecond),
+		"submit should wait for the running task to finish on a saturated pool")
+}
+
+func TestSubmitWithRecover(t *testing.T) {
+	var poolPanics, taskPanics int32
+	p, _ := NewPool(1, WithPanicHandler(func(interface{}) {
+		atomic.AddInt32(&poolPanics, 1)
+	}))
+	defer p.Release()
+
+	recovered := make(chan interface{}, 1)
+	err := p.SubmitWithRecover(func() {
+		panic("Oops!")
+	}, func(r interface{}) {
+		atomic.AddInt32(&taskPanics, 1)
+		recovered <- r
+	})
+	assert.NoError(t, err)
+	assert.EqualValues(t, "Oops!", <-recovered, "per-task handler should get the panic value")
+	assert.NoError(t, p.ReleaseTimeout(time.Second))
+	assert.EqualValues(t, 1, atomic.LoadInt32(&taskPanics), "per-task handler should be called once")
+	assert.EqualValues(t, 0, atomic.LoadInt32(&poolPanics), "pool panic handler should not be called")
+}
+
+func TestSaturationSignal(t *testing.T) {
+	p, _ := NewPool(2)
+	defer p.Release()
+
+	saturation, desaturation := p.SaturationSignal(), p.DesaturationSignal()
+	ch := make(chan struct{})
+	for i := 0; i < p.Cap(); i++ {
+		_ = p.Submit(func() { <-ch })
+	}
+	select {
+	case <-saturation:
+	case <-time.After(time.Second):
+		t.Fatal("expect a saturation signal after all workers got busy")
+	}
+
+	ch <- struct{}{}
+	select {
+	case <-desaturation:
+	case <-time.After(time.Second):
+		t.Fatal("expect a desaturation signal after a worker became idle")
+	}
+	close(ch)
+}
+
+func TestSaturationSignalHandoff(t *testing.T) {
+	p, _ := NewPool(2)
+	defer p.Release()
+
+	saturation, desaturation := p.SaturationSignal(), p.DesaturationSignal()
+	ch := make(chan struct{})
+	for i := 0; i < p.Cap(); i++ {
+		assert.NoError(t, p.Submit(func() { <-ch }))
+	}
+	<-saturation
+	for i := 0; i < 3; i++ {
+		go func() { _ = p.Submit(func() { <-ch }) }()
+	}
+	assert.Eventually(t, func() bool { return p.Waiting() == 3 }, time.Second, time.Millisecond)
+
+	// Each finished task hands its worker over to a waiting one, the pool stays saturated throughout.
+	for i := 3; i > 0; i-- {
+		ch <- struct{}{}
+		assert.Eventually(t, func() bool { return p.Waiting() == i-1 }, time.Second, time.Millisecond)
+	}
+	assert.Eventually(t, func() bool { return p.busyWorkers() == 2 }, time.Second, time.Millisecond)
+	select {
+	case <-desaturation:
+		t.Fatal("a worker handed over to a waiting task should not desaturate the pool")
+	default:
+	}
+
+	close(ch)
+	select {
+	case <-desaturation:
+	case <-time.After(time.Second):
+		t.Fatal("expect a desaturation signal once the workers became idle")
+	}
+}
+
+func TestWithOnFull(t *testing.T) {
+	var full int32
+	p, _ := NewPool(3, WithOnFull(func() { atomic.AddInt32(&full, 1) }))
+	defer p.Release()
+
+	ch := make(chan struct{})
+	for i := 0; i < p.Cap(); i++ {
+		assert.NoError(t, p.Submit(func() { <-ch }))
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&full), "the callback should fire once the pool hits the ceiling")
+
+	// Staying at full capacity is the same saturation episode.
+	for i := 0; i < 2; i++ {
+		go func() { _ = p.Submit(func() { <-ch }) }()
+	}
+	assert.Eventually(t, func() bool { return p.Waiting() == 2 }, time.Second, time.Millisecond)
+	ch <- struct{}{}
+	assert.Eventually(t, func() bool { return p.Waiting() == 1 }, time.Second, time.Millisecond)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&full), "the callback should fire at most once per saturation episode")
+
+	// A new episode begins once the pool drops below full capacity.
+	ch <- struct{}{}
+	ch <- struct{}{}
+	assert.Eventually(t, func() bool { return p.Free() == 0 && p.Waiting() == 0 }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		return p.workers.len() == 1
+	}, time.Second, time.Millisecond)
+	assert.NoError(t, p.Submit(func() { <-ch }))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&full))
+	close(ch)
+}
+
+func TestNoReuse(t *testing.T) {
+	const tasks = 5
+	var mu sync.Mutex
+	ids := make(map[uint64]struct{})
+	var wg sync.WaitGroup
+	record := func() {
+		mu.Lock()
+		ids[goroutineID()] = struct{}{}
+		mu.Unlock()
+		wg.Done()
+	}
+
+	p, _ := NewPool(1, WithNoReuse(true))
+	defer p.Release()
+	wg.Add(tasks)
+	for i := 0; i < tasks; i++ {
+		_ = p.Submit(record)
+	}
+	wg.Wait()
+	assert.Len(t, ids, tasks, "each task should run on a distinct goroutine")
+
+	ids = make(map[uint64]struct{})
+	pf, _ := NewPoolWithFunc(1, func(interface{}) { record() }, WithNoReuse(true))
+	defer pf.Release()
+	wg.Add(tasks)
+	for i := 0; i < tasks; i++ {
+		_ = pf.Invoke(i)
+	}
+	wg.Wait()
+	assert.Len(t, ids, tasks, "each task should run on a distinct goroutine")
+}
+
+func TestSubmitIfAvailable(t *testing.T) {
+	p, _ := NewPool(2)
+	defer p.Release()
+
+	ch := make(chan struct{})
+	for i := 0; i < p.Cap(); i++ {
+		ok, err := p.SubmitIfAvailable(func() { <-ch })
+		assert.NoError(t, err)
+		assert.True(t, ok, "submit should succeed when there is free capacity")
+	}
+	ok, err := p.SubmitIfAvailable(demoFunc)
+	assert.NoError(t, err, "submit to a saturated pool should not be an error")
+	assert.False(t, ok, "submit should be refused when the pool is saturated")
+	assert.EqualValues(t, p.Cap(), p.Running(), "submit should not spawn workers beyond the capacity")
+	close(ch)
+
+	p.Release()
+	ok, err = p.SubmitIfAvailable(demoFunc)
+	assert.EqualError(t, err, ErrPoolClosed.Error(), "pool should be closed")
+	assert.False(t, ok)
+}
+
+func TestReleaseInOrder(t *testing.T) {
+	upstream, _ := NewPool(5)
+	downstream, _ := NewPool(5)
+
+	const tasks = 10
+	var forwarded, processed int32
+	for i := 0; i < tasks; i++ {
+		_ = upstream.Submit(func() {
+			time.Sleep(20 * time.Millisecond)
+			if err := downstream.Submit(func() {
+				time.Sleep(20 * time.Millisecond)
+				atomic.AddInt32(&processed, 1)
+			}); err == nil {
+				atomic.AddInt32(&forwarded, 1)
+			}
+		})
+	}
+	assert.NoError(t, ReleaseInOrder(time.Second, upstream, downstream))
+	assert.True(t, upstream.IsClosed() && downstream.IsClosed(), "all pools should be closed")
+	assert.EqualValues(t, tasks, atomic.LoadInt32(&forwarded), "downstream should stay open until upstream drained")
+	assert.EqualValues(t, tasks, atomic.LoadInt32(&processed), "downstream should drain before returning")
+	assert.EqualError(t, ReleaseInOrder(time.Second, upstream, downstream), ErrPoolClosed.Error(), "pools should be closed")
+
+	upstream, _ = NewPool(1)
+	downstream, _ = NewPool(1)
+	block := make(chan struct{})
+	_ = upstream.Submit(func() { <-block })
+	assert.EqualError(t, ReleaseInOrder(20*time.Millisecond, upstream, downstream), ErrTimeout.Error(),
+		"the blocked worker should time out the release")
+	assert.True(t, upstream.IsClosed(), "the current pool should be closed")
+	assert.False(t, downstream.IsClosed(), "the pools after the current one should stay open")
+	close(block)
+	downstream.Release()
+}
+
+func TestAvailabilityChan(t *testing.T) {
+	p, _ := NewPool(1)
+	defer p.Release()
+
+	ch := make(chan struct{})
+	_ = p.Submit(func() { <-ch })
+	select {
+	case <-p.AvailabilityChan():
+		t.Fatal("no worker should be available while the pool is saturated")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(ch)
+	select {
+	case <-p.AvailabilityChan():
+	case <-time.After(time.Second):
+		t.Fatal("expect a receive after the running task completed")
+	}
+	ok, err := p.SubmitIfAvailable(demoFunc)
+	assert.NoError(t, err)
+	assert.True(t, ok, "a worker should be available")
+}
+
+func TestSubmitOnce(t *testing.T) {
+	p, _ := NewPool(10)
+	defer p.Release()
+
+	var runs, submitted int32
+	ch := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := p.SubmitOnce("key", func() {
+				atomic.AddInt32(&runs, 1)
+				<-ch
+			})
+			assert.NoError(t, err)
+			if ok {
+				atomic.AddInt32(&submitted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&submitted), "only one of the tasks with the same key should be submitted")
+	close(ch)
+
+	// The key should be released once the in-flight task completes.
+	done := make(chan struct{})
+	for {
+		ok, err := p.SubmitOnce("key", func() { close(done) })
+		assert.NoError(t, err)
+		if ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	<-done
+	assert.EqualValues(t, 1, atomic.LoadInt32(&runs), "deduplicated task should never run")
+}
+
+func TestSnapshot(t *testing.T) {
+	p, _ := NewPool(10, WithNoReuse(true))
+	defer p.Release()
+	pf, _ := NewPoolWithFunc(10, func(interface{}) {}, WithNoReuse(true))
+	defer pf.Release()
+
+	ch := make(chan struct{})
+	for i := 0; i < 5; i++ {
+		_ = p.Submit(func() { <-ch })
+	}
+	capacity, running, free := p.Snapshot()
+	assert.Equal(t, [3]int{10, 5, 5}, [3]int{capacity, running, free})
+
+	// Tune the pools and let their workers come and go concurrently.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10000; i++ {
+			p.Tune(5 + i%6)
+			pf.Tune(5 + i%6)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			_, _ = p.SubmitIfAvailable(func() {})
+			_ = pf.Invoke(nil)
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	check := func(capacity, running, free int) {
+		assert.True(t, capacity >= 5 && capacity <= 10, "the capacity should be one of the tuned values, got %d", capacity)
+		assert.GreaterOrEqual(t, running, 0)
+		if capacity > running {
+			assert.EqualValues(t, capacity-running, free, "inconsistent snapshot")
+		} else {
+			assert.Zero(t, free, "free should never be negative")
+		}
+	}
+	for {
+		select {
+		case <-done:
+			close(ch)
+			return
+		default:
+		}
+		check(p.Snapshot())
+		check(pf.Snapshot())
+		assert.GreaterOrEqual(t, p.Free(), 0, "free should never be negative")
+		assert.GreaterOrEqual(t, pf.Free(), 0, "free should never be negative")
+	}
+}
+
+func TestCapacityPerCPU(t *testing.T) {
+	procs := runtime.GOMAXPROCS(0)
+	defer runtime.GOMAXPROCS(procs)
+
+	p, _ := NewPool(0, WithCapacityPerCPU(4))
+	defer p.Release()
+	assert.EqualValues(t, 4*procs, p.Cap(), "capacity should be a multiple of GOMAXPROCS")
+
+	runtime.GOMAXPROCS(procs + 1)
+	p.RecomputeCapacity()
+	assert.EqualValues(t, 4*(procs+1), p.Cap(), "capacity should follow the new GOMAXPROCS")
+
+	pf, _ := NewPoolWithFunc(-1, demoPoolFunc, WithCapacityPerCPU(2))
+	defer pf.Release()
+	assert.EqualValues(t, 2*(procs+1), pf.Cap(), "capacity should be a multiple of GOMAXPROCS")
+
+	p1, _ := NewPool(10, WithCapacityPerCPU(4))
+	defer p1.Release()
+	assert.EqualValues(t, 10, p1.Cap(), "an explicit size should take precedence")
+}
+
+func TestPanicQuarantine(t *testing.T) {
+	var panics int32
+	p, _ := NewPool(1, WithPanicQuarantine(2), WithPanicHandler(func(interface{}) {
+		atomic.AddInt32(&panics, 1)
+	}))
+	defer p.Release()
+
+	ids := make(chan uint64, 4)
+	for i := 0; i < 4; i++ {
+		_ = p.Submit(func() {
+			ids <- goroutineID()
+			panic("Oops!")
+		})
+	}
+	id1, id2, id3, id4 := <-ids, <-ids, <-ids, <-ids
+	assert.Equal(t, id1, id2, "worker should survive the panics below the threshold")
+	assert.NotEqual(t, id2, id3, "worker should be replaced after reaching the threshold")
+	assert.Equal(t, id3, id4, "the replacement worker should survive the panics below the threshold")
+	assert.NoError(t, p.ReleaseTimeout(time.Second))
+	assert.EqualValues(t, 4, atomic.LoadInt32(&panics), "every panic should be handled")
+
+	pf, _ := NewPoolWithFunc(1, func(interface{}) {
+		ids <- goroutineID()
+		panic("Oops!")
+	}, WithPanicQuarantine(1), WithPanicHandler(func(interface{}) {}))
+	defer pf.Release()
+	_ = pf.Invoke(1)
+	_ = pf.Invoke(1)
+	assert.NotEqual(t, <-ids, <-ids, "worker should be replaced after reaching the threshold")
+}
+
+func TestPanicQuarantineReusedWorker(t *testing.T) {
+	ids := make(chan uint64, 2)
+	p, _ := NewPool(1, WithPanicQuarantine(3), WithPanicHandler(func(interface{}) {}))
+	defer p.Release()
+	// Start a worker from the cache as if its previous goroutine had recovered from two panics.
+	w := p.workerCache.Get().(*goWorker)
+	w.panics = 2
+	w.run()
+	task := func() {
+		ids <- goroutineID()
+		panic("Oops!")
+	}
+	w.inputFunc(task)
+	_ = p.Submit(task)
+	assert.Equal(t, <-ids, <-ids, "the panics of a previous goroutine should not count towards the quarantine")
+
+	pf, _ := NewPoolWithFunc(1, func(interface{}) {
+		ids <- goroutineID()
+		panic("Oops!")
+	}, WithPanicQuarantine(3), WithPanicHandler(func(interface{}) {}))
+	defer pf.Release()
+	wf := pf.workerCache.Get().(*goWorkerWithFunc)
+	wf.panics = 2
+	wf.run()
+	wf.inputParam(1)
+	_ = pf.Invoke(1)
+	assert.Equal(t, <-ids, <-ids, "the panics of a previous goroutine should not count towards the quarantine")
+}
+
+func TestTuneAndWait(t *testing.T) {
+	p, _ := NewPool(2)
+	defer p.Release()
+	p.Tune(8)
+
+	ch := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		_ = p.Submit(func() { <-ch })
+	}
+	assert.EqualValues(t, 8, p.Running())
+	assert.EqualError(t, p.TuneAndWait(3, 50*time.Millisecond), ErrTimeout.Error(),
+		"shrink should not take effect while the excess workers are busy")
+
+	// Finish the tasks one by one, the excess workers should exit while the others stay idle.
+	go func() {
+		for i := 0; i < 8; i++ {
+			ch <- struct{}{}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	assert.NoError(t, p.TuneAndWait(3, 2*time.Second))
+	assert.LessOrEqual(t, p.Running(), 3, "running workers should converge to the new capacity")
+	assert.EqualValues(t, 3, p.Cap())
+
+	pf, _ := NewPoolWithFunc(5, demoPoolFunc)
+	defer pf.Release()
+	for i := 0; i < 5; i++ {
+		_ = pf.Invoke(10)
+	}
+	assert.NoError(t, pf.TuneAndWait(1, 2*time.Second))
+	assert.LessOrEqual(t, pf.Running(), 1, "running workers should converge to the new capacity")
+}
+
+func TestSubmitSafe(t *testing.T) {
+	p, _ := NewPool(10)
+	defer p.Release()
+
+	assert.NoError(t, p.SubmitSafe(func() error { return nil }))
+	errTask := errors.New("task failed")
+	assert.Equal(t, errTask, p.SubmitSafe(func() error { return errTask }), "error from the task should be returned")
+
+	err := p.SubmitSafe(func() error {
+		panic("Oops!")
+	})
+	var pe *PanicError
+	assert.True(t, errors.As(err, &pe), "panic should be converted into a PanicError")
+	assert.EqualValues(t, "Oops!", pe.Value)
+	assert.Contains(t, err.Error(), "Oops!", "error should contain the panic value")
+	assert.NotEmpty(t, pe.Stack, "error should contain the stack trace")
+
+	p.Release()
+	assert.EqualError(t, p.SubmitSafe(func() error { return nil }), ErrPoolClosed.Error(), "pool should be closed")
+}
+
+func TestUserData(t *testing.T) {
+	p, _ := NewPool(10)
+	defer p.Release()
+	assert.Nil(t, p.UserData(), "pool should have no user data by default")
+
+	type tag struct{ name string }
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.SetUserData(&tag{"io"})
+			_ = p.UserData()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, &tag{"io"}, p.UserData())
+	p.SetUserData("another type")
+	assert.Equal(t, "another type", p.UserData(), "user data should accept values of different types")
+	p.SetUserData(nil)
+	assert.Nil(t, p.UserData(), "user data should be cleared")
+
+	pf, _ := NewPoolWithFunc(10, demoPoolFunc)
+	defer pf.Release()
+	pf.SetUserData(42)
+	assert.Equal(t, 42, pf.UserData())
+}
+
+func TestSaturationAlert(t *testing.T) {
+	var alerts int32
+	p, _ := NewPool(1, WithSaturationAlert(time.Second, func() {
+		atomic.AddInt32(&alerts, 1)
+	}))
+	defer p.Release()
+
+	ch := make(chan struct{})
+	_ = p.Submit(func() { <-ch })
+	go func() {
+		_ = p.Submit(demoFunc)
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+	assert.EqualValues(t, 0, atomic.LoadInt32(&alerts), "alert should not fire before the duration elapses")
+	time.Sleep(2500 * time.Millisecond)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&alerts), "alert should fire once per saturation episode")
+	close(ch)
+}
+
+func TestSession(t *testing.T) {
+	p, _ := NewPool(1)
+	defer p.Release()
+
+	s, err := p.NewSession()
+	assert.NoErrorf(t, err, "create session failed: %v", err)
+
+	const tasks = 5
+	var order []int
+	ids := make(map[uint64]struct{})
+	for i := 0; i < tasks; i++ {
+		i := i
+		assert.NoError(t, s.Run(func() {
+			// No lock is needed since the tasks of a session run sequentially on the same goroutine.
+			order = append(order, i)
+			ids[goroutineID()] = struct{}{}
+		}))
+	}
+	ok, err := p.SubmitIfAvailable(demoFunc)
+	assert.NoError(t, err)
+	assert.False(t, ok, "the worker held by the session should count against the capacity")
+
+	s.Close()
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, order, "tasks should run in order")
+	assert.Len(t, ids, 1, "tasks should run on the same goroutine")
+	assert.EqualError(t, s.Run(demoFunc), ErrSessionClosed.Error(), "session should be closed")
+	s.Close()
+
+	done := make(chan struct{})
+	assert.NoError(t, p.Submit(func() { close(done) }), "the worker should be put back after the session closed")
+	<-done
+
+	sp, _ := NewPool(1, WithSynchronous(true))
+	defer sp.Release()
+	_, err = sp.NewSession()
+	assert.EqualError(t, err, ErrSynchronousSession.Error(), "a session should not run inline")
+}
+
+func TestTryTune(t *testing.T) {
+	p, _ := NewPool(4)
+	defer p.Release()
+
+	ch := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		_ = p.Submit(func() { <-ch })
+	}
+	assert.EqualError(t, p.TryTune(2), ErrWouldDropWork.Error(), "shrink below the busy workers should be refused")
+	assert.EqualValues(t, 4, p.Cap(), "capacity should be unchanged")
+	assert.NoError(t, p.TryTune(4))
+	assert.NoError(t, p.TryTune(8), "growth should always succeed")
+	assert.EqualValues(t, 8, p.Cap())
+	assert.NoError(t, p.TryTune(5), "shrink above the busy workers should succeed")
+	assert.EqualValues(t, 5, p.Cap())
+	close(ch)
+
+	pf, _ := NewPoolWithFunc(2, longRunningPoolFunc)
+	defer pf.Release()
+	pfch := make(chan struct{})
+	_ = pf.Invoke(pfch)
+	_ = pf.Invoke(pfch)
+	assert.EqualError(t, pf.TryTune(1), ErrWouldDropWork.Error(), "shrink below the busy workers should be refused")
+	assert.EqualValues(t, 2, pf.Cap(), "capacity should be unchanged")
+	close(pfch)
+}
+
+func TestWithMiddleware(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		trace []string
+	)
+	record := func(s string) {
+		mu.Lock()
+		trace = append(trace, s)
+		mu.Unlock()
+	}
+	middleware := func(name string) func(func()) func() {
+		return func(next func()) func() {
+			return func() {
+				record(name + " before")
+				next()
+				record(name + " after")
+			}
+		}
+	}
+	p, _ := NewPool(1, WithMiddleware(middleware("outer")), WithMiddleware(middleware("inner")))
+	defer p.Release()
+
+	done := make(chan struct{})
+	assert.NoError(t, p.Submit(func() { record("task") }))
+	assert.NoError(t, p.Submit(func() { close(done) }))
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.EqualValues(t, []string{"outer before", "inner before", "task", "inner after", "outer after"}, trace[:5])
+}
+
+func TestWithContextPropagation(t *testing.T) {
+	var local sync.Map // goroutine ID -> context, a stand-in for goroutine-local storage.
+	extract := func() interface{} {
+		v, _ := local.Load(goroutineID())
+		return v
+	}
+	inject := func(v interface{}) {
+		if v == nil {
+			local.Delete(goroutineID())
+			return
+		}
+		local.Store(goroutineID(), v)
+	}
+	p, _ := NewPool(1, WithContextPropagation(extract, inject))
+	defer p.Release()
+
+	local.Store(goroutineID(), "span-1")
+	observed := make(chan interface{}, 1)
+	assert.NoError(t, p.Submit(func() { observed <- extract() }))
+	assert.EqualValues(t, "span-1", <-observed, "the task should observe the context of its submitter")
+
+	local.Delete(goroutineID())
+	assert.NoError(t, p.Submit(func() { observed <- extract() }))
+	assert.Nil(t, <-observed, "the context should be cleared after the previous task")
+}
+
+func TestReleaseLeaksNoGoroutine(t *testing.T) {
+	settle := func(limit int) int {
+		n := runtime.NumGoroutine()
+		for i := 0; i < 100 && n > limit; i++ {
+			time.Sleep(10 * time.Millisecond)
+			n = runtime.NumGoroutine()
+		}
+		return n
+	}
+	base := settle(0)
+	for i := 0; i < 100; i++ {
+		p, _ := NewPool(10, WithExpiryDuration(time.Millisecond))
+		pf, _ := NewPoolWithFunc(10, demoPoolFunc, WithExpiryDuration(time.Millisecond))
+		for j := 0; j < 10; j++ {
+			_ = p.Submit(demoFunc)
+			_ = pf.Invoke(1)
+		}
+		p.Release()
+		pf.Release()
+	}
+	// Leave a little room for the goroutines owned by the runtime and the testing package.
+	n := settle(base + 2)
+	assert.LessOrEqualf(t, n, base+2, "%d goroutines leaked after releasing the pools", n-base)
+}
+
+type countingJob struct {
+	runs int32
+	done chan struct{}
+}
+
+func (j *countingJob) Run() {
+	atomic.AddInt32(&j.runs, 1)
+	close(j.done)
+}
+
+func TestSubmitJob(t *testing.T) {
+	p, _ := NewPool(1)
+	defer p.Release()
+
+	j := &countingJob{done: make(chan struct{})}
+	assert.NoError(t, p.SubmitJob(j))
+	<-j.done
+	assert.EqualValues(t, 1, atomic.LoadInt32(&j.runs), "Run of the job should be invoked once")
+
+	p.Release()
+	assert.EqualError(t, p.SubmitJob(&countingJob{done: make(chan struct{})}), ErrPoolClosed.Error())
+}
+
+func TestReleaseTimeoutWithProgress(t *testing.T) {
+	p, _ := NewPool(3)
+	for i := 1; i <= 3; i++ {
+		d := time.Duration(i) * 100 * time.Millisecond
+		_ = p.Submit(func() { time.Sleep(d) })
+	}
+	var reports []int
+	assert.NoError(t, p.ReleaseTimeoutWithProgress(2*time.Second, func(remaining int) {
+		reports = append(reports, remaining)
+	}))
+	assert.EqualValues(t, []int{3, 2, 1, 0}, reports, "the progress should observe a decreasing remaining count")
+
+	pf, _ := NewPoolWithFunc(2, demoPoolFunc)
+	_ = pf.Invoke(100)
+	_ = pf.Invoke(200)
+	reports = reports[:0]
+	assert.NoError(t, pf.ReleaseTimeoutWithProgress(2*time.Second, func(remaining int) {
+		reports = append(reports, remaining)
+	}))
+	assert.EqualValues(t, []int{2, 1, 0}, reports, "the progress should observe a decreasing remaining count")
+	assert.EqualError(t, pf.ReleaseTimeoutWithProgress(time.Second, nil), ErrPoolClosed.Error())
+}
+
+func TestSubmitBatchLimited(t *testing.T) {
+	p, _ := NewPool(10)
+	defer p.Release()
+
+	var running, peak, done int32
+	tasks := make([]func(), 8)
+	for i := range tasks {
+		tasks[i] = func() {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&peak)
+				if n <= m || atomic.CompareAndSwapInt32(&peak, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&done, 1)
+		}
+	}
+	assert.NoError(t, p.SubmitBatchLimited(tasks, 2))
+	assert.EqualValues(t, 8, atomic.LoadInt32(&done), "the batch should have completed")
+	assert.EqualValues(t, 2, atomic.LoadInt32(&peak), "no more than 2 tasks of the batch should run simultaneously")
+
+	p.Release()
+	assert.EqualError(t, p.SubmitBatchLimited(tasks, 2), ErrPoolClosed.Error())
+}
+
+type recordingLogger struct {
+	mu   sync.Mutex
+	logs []string
+}
+
+func (l *recordingLogger) Printf(format string, args ...interface{}) {
+	l.mu.Lock()
+	l.logs = append(l.logs, fmt.Sprintf(format, args...))
+	l.mu.Unlock()
+}
+
+func (l *recordingLogger) contains(substr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, s := range l.logs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func stuckTask(ch chan struct{}) {
+	<-ch
+}
+
+func TestWithStuckTaskDump(t *testing.T) {
+	logger := &recordingLogger{}
+	p, _ := NewPool(2, WithStuckTaskDump(100*time.Millisecond), WithLogger(logger))
+	defer p.Release()
+
+	ch := make(chan struct{})
+	_ = p.Submit(func() { stuckTask(ch) })
+	_ = p.Submit(func() {})
+	assert.Eventually(t, func() bool { return logger.contains("ants/v2.stuckTask") },
+		2*time.Second, 10*time.Millisecond, "the stack of the stuck task should be dumped")
+	close(ch)
+
+	logger.mu.Lock()
+	assert.Len(t, logger.logs, 1, "only the stuck task should be dumped, and only once")
+	logger.mu.Unlock()
+
+	pf, _ := NewPoolWithFunc(1, func(arg interface{}) { stuckTask(arg.(chan struct{})) },
+		WithStuckTaskDump(100*time.Millisecond), WithLogger(logger))
+	defer pf.Release()
+	pfch := make(chan struct{})
+	_ = pf.Invoke(pfch)
+	assert.Eventually(t, func() bool {
+		logger.mu.Lock()
+		defer logger.mu.Unlock()
+		return len(logger.logs) == 2
+	}, 2*time.Second, 10*time.Millisecond, "the stack of the stuck task should be dumped")
+	close(pfch)
+}
+
+func TestPoolManager(t *testing.T) {
+	m := NewPoolManager()
+	defer m.Close()
+
+	base := runtime.NumGoroutine()
+	pools := make([]*Pool, 10)
+	for i := range pools {
+		pools[i], _ = m.NewPool(2, WithExpiryDuration(100*time.Millisecond))
+		_ = pools[i].Submit(demoFunc)
+	}
+	assert.EqualValues(t, 10, m.Len())
+	assert.Eventually(t, func() bool {
+		for _, p := range pools {
+			if p.Running() > 0 {
+				return false
+			}
+		}
+		return true
+	}, 3*time.Second, 10*time.Millisecond, "the stale workers of the managed pools should be purged")
+	assert.LessOrEqual(t, runtime.NumGoroutine(), base, "the managed pools should start no background goroutine")
+
+	clone, err := pools[0].Clone()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 11, m.Len(), "the clone of a managed pool should be managed as well")
+	assert.NoError(t, clone.ReleaseTimeout(time.Second))
+
+	for _, p := range pools {
+		assert.NoError(t, p.ReleaseTimeout(time.Second))
+	}
+	assert.EqualValues(t, 0, m.Len(), "the released pools should be deregistered")
+
+	pools[0].Reboot()
+	assert.EqualValues(t, 1, m.Len(), "the rebooted pool should be registered again")
+	assert.NoError(t, pools[0].Submit(func() {}))
+	pools[0].Release()
+}
+
+func TestWithDeadLetter(t *testing.T) {
+	type letter struct {
+		task     func()
+		panicVal interface{}
+	}
+	letters := make(chan letter, 1)
+	p, _ := NewPool(1, WithDeadLetter(func(task func(), panicVal interface{}) {
+		letters <- letter{task, panicVal}
+	}))
+	defer p.Release()
+
+	var calls int32
+	poison := func() {
+		atomic.AddInt32(&calls, 1)
+		panic("poison")
+	}
+	assert.NoError(t, p.Submit(poison))
+	l := <-letters
+	assert.EqualValues(t, "poison", l.panicVal)
+
+	// The dead letter carries the original task, so it can be run again.
+	assert.Panics(t, l.task)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+
+	done := make(chan struct{})
+	assert.NoError(t, p.Submit(func() { close(done) }))
+	<-done
+	assert.EqualValues(t, 1, p.Running(), "the worker should survive the panic")
+}
+
+func TestDrainTo(t *testing.T) {
+	src, _ := NewPool(1)
+	defer src.Release()
+	dst, _ := NewPool(3)
+	defer dst.Release()
+
+	block := make(chan struct{})
+	_ = src.Submit(func() { <-block })
+
+	var wg sync.WaitGroup
+	ran := make(chan struct{}, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, src.Submit(func() {
+				ran <- struct{}{}
+				<-block
+			}))
+		}()
+	}
+	assert.Eventually(t, func() bool { return src.Waiting() == 3 }, time.Second, time.Millisecond)
+
+	moved, err := src.DrainTo(dst)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, moved)
+	wg.Wait()
+	for i := 0; i < 3; i++ {
+		<-ran
+	}
+	assert.EqualValues(t, 0, src.Waiting())
+	assert.EqualValues(t, 1, src.Running(), "the moved tasks should not run on the source pool")
+	assert.EqualValues(t, 3, dst.Running(), "the moved tasks should run on the destination pool")
+	close(block)
+
+	_, err = src.DrainTo(src)
+	assert.EqualError(t, err, ErrDrainToSelf.Error())
+	dst.Release()
+	_, err = src.DrainTo(dst)
+	assert.EqualError(t, err, ErrPoolClosed.Error())
+}
+
+func TestWithMaxIdleWorkers(t *testing.T) {
+	p, _ := NewPool(100, WithMaxIdleWorkers(5))
+	defer p.Release()
+
+	var wg sync.WaitGroup
+	block := make(chan struct{})
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		_ = p.Submit(func() {
+			defer wg.Done()
+			<-block
+		})
+	}
+	assert.EqualValues(t, 50, p.Running())
+	close(block)
+	wg.Wait()
+	assert.Eventually(t, func() bool { return p.Running() == 5 }, time.Second, time.Millisecond,
+		"the idle workers should be capped at 5 after the spike")
+	p.lock.Lock()
+	assert.EqualValues(t, 5, p.workers.len())
+	p.lock.Unlock()
+
+	pf, _ := NewPoolWithFunc(10, longRunningPoolFunc, WithMaxIdleWorkers(2))
+	defer pf.Release()
+	pfch := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		_ = pf.Invoke(pfch)
+	}
+	close(pfch)
+	assert.Eventually(t, func() bool { return pf.Running() == 2 }, time.Second, time.Millisecond,
+		"the idle workers should be capped at 2 after the spike")
+}
+
+func TestWithCaptureCaller(t *testing.T) {
+	p, _ := NewPool(2, WithCaptureCaller(true))
+	defer p.Release()
+
+	block := make(chan struct{})
+	_, file, line, _ := runtime.Caller(0)
+	_ = p.Submit(func() { <-block })
+	_, _ = p.SubmitOnce("key", func() { <-block })
+	assert.Eventually(t, func() bool { return len(p.InflightCallers()) == 2 }, time.Second, time.Millisecond)
+	for _, caller := range p.InflightCallers() {
+		assert.Equal(t, file, caller.File)
+		assert.Contains(t, []int{line + 1, line + 2}, caller.Line, "the caller should point at the submission site")
+		assert.True(t, strings.HasSuffix(caller.Function, "TestWithCaptureCaller"))
+	}
+	close(block)
+	assert.Eventually(t, func() bool { return len(p.InflightCallers()) == 0 }, time.Second, time.Millisecond,
+		"the callers should be forgotten once the tasks complete")
+
+	// The helpers of the package submitting on behalf of the caller are skipped as well.
+	block = make(chan struct{})
+	_, file, line, _ = runtime.Caller(0)
+	_ = p.SubmitClass("class", func() { <-block })
+	_ = p.NewWaitGroup().Add(func() { <-block })
+	assert.Eventually(t, func() bool { return len(p.InflightCallers()) == 2 }, time.Second, time.Millisecond)
+	for _, caller := range p.InflightCallers() {
+		assert.Equal(t, file, caller.File)
+		assert.Contains(t, []int{line + 1, line + 2}, caller.Line, "the caller should point at the submission site")
+		assert.True(t, strings.HasSuffix(caller.Function, "TestWithCaptureCaller"))
+	}
+	close(block)
+	assert.Eventually(t, func() bool { return len(p.InflightCallers()) == 0 }, time.Second, time.Millisecond,
+		"the callers should be forgotten once the tasks complete")
+}
+
+// semaphoreAdmitter admits at most cap(sem) tasks at the same time.
+type semaphoreAdmitter struct {
+	sem chan struct{}
+}
+
+func (a semaphoreAdmitter) Acquire(ctx context.Context) error {
+	select {
+	case a.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (a semaphoreAdmitter) Release() {
+	<-a.sem
+}
+
+func (a semaphoreAdmitter) TryAcquire() bool {
+	select {
+	case a.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestWithAdmitter(t *testing.T) {
+	p, _ := NewPool(10, WithAdmitter(semaphoreAdmitter{make(chan struct{}, 1)}))
+	defer p.Release()
+
+	var running, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		assert.NoError(t, p.Submit(func() {
+			defer wg.Done()
+			n := atomic.AddInt32(&running, 1)
+			if n > atomic.LoadInt32(&peak) {
+				atomic.StoreInt32(&peak, n)
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}))
+	}
+	wg.Wait()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&peak), "the admitter should be honored regardless of the pool size")
+
+	errRejected := errors.New("rejected")
+	p, _ = NewPool(10, WithAdmitter(rejectingAdmitter{errRejected}))
+	defer p.Release()
+	assert.Equal(t, errRejected, p.Submit(func() {}), "the error of the admitter should be returned")
+}
+
+func TestAdmitterNonblocking(t *testing.T) {
+	p, _ := NewPool(10, WithAdmitter(semaphoreAdmitter{make(chan struct{}, 1)}))
+	defer p.Release()
+	block := make(chan struct{})
+	assert.NoError(t, p.Submit(func() { <-block }))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ok, err := p.SubmitIfAvailable(demoFunc)
+		assert.False(t, ok)
+		assert.EqualError(t, err, ErrPoolOverload.Error(), "the task should not be admitted")
+		assert.EqualError(t, p.SubmitReserving(demoFunc, 1), ErrPoolOverload.Error())
+		assert.EqualError(t, p.SubmitReentrant(demoFunc), ErrPoolOverload.Error())
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the submissions which never block should not block on the admitter")
+	}
+	close(block)
+}
+
+type ctxKey struct{}
+
+// contextAdmitter records the value of ctxKey in the context of every admission.
+type contextAdmitter struct {
+	values chan interface{}
+}
+
+func (a contextAdmitter) Acquire(ctx context.Context) error {
+	a.values <- ctx.Value(ctxKey{})
+	return ctx.Err()
+}
+
+func (contextAdmitter) Release() {}
+
+func TestAdmitterContext(t *testing.T) {
+	admitter := contextAdmitter{make(chan interface{}, 1)}
+	p, _ := NewPool(10, WithAdmitter(admitter))
+	defer p.Release()
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+	assert.NoError(t, p.SubmitWithContext(ctx, func(context.Context) {}))
+	assert.Equal(t, "request", <-admitter.values, "the context of the submission should reach the admitter")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Equal(t, context.Canceled, p.SubmitWithContext(cancelled, func(context.Context) {}))
+	<-admitter.values
+}
+
+type rejectingAdmitter struct {
+	err error
+}
+
+func (a rejectingAdmitter) Acquire(context.Context) error {
+	return a.err
+}
+
+func (rejectingAdmitter) Release() {}
+
+func TestNewPoolWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err := NewPoolWithContext(ctx, 10)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Submit(demoFunc))
+	cancel()
+	assert.Eventually(t, p.IsClosed, time.Second, time.Millisecond, "the pool should be released once the context is cancelled")
+	assert.EqualError(t, p.Submit(demoFunc), ErrPoolClosed.Error())
+
+	// Releasing the pool explicitly should stop watching the context.
+	base := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		p, _ = NewPoolWithContext(context.Background(), 10)
+		assert.NoError(t, p.ReleaseTimeout(time.Second))
+	}
+	assert.Eventually(t, func() bool { return runtime.NumGoroutine() <= base }, time.Second, 10*time.Millisecond,
+		"the goroutines watching the contexts should exit")
+
+	_, err = NewPoolWithContext(context.Background(), 10, WithExpiryDuration(-1))
+	assert.EqualError(t, err, ErrInvalidPoolExpiry.Error())
+}
+
+func TestBroadcast(t *testing.T) {
+	p, _ := NewPool(4)
+	defer p.Release()
+
+	// config is the worker-local state, keyed by the goroutine ID of each worker.
+	var config sync.Map
+	block := make(chan struct{})
+	var started sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		started.Add(1)
+		_ = p.Submit(func() {
+			config.Store(goroutineID(), 1)
+			started.Done()
+			<-block
+		})
+	}
+	started.Wait()
+	// Let two of the workers become idle, the other two stay busy.
+	block <- struct{}{}
+	block <- struct{}{}
+	assert.Eventually(t, func() bool {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		return p.workers.len() == 2
+	}, time.Second, time.Millisecond)
+
+	var runs int32
+	done := make(chan error)
+	go func() {
+		done <- p.Broadcast(func() {
+			atomic.AddInt32(&runs, 1)
+			config.Store(goroutineID(), 2)
+		})
+	}()
+	select {
+	case <-done:
+		t.Fatal("Broadcast should wait for the busy workers")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(block)
+	assert.NoError(t, <-done)
+	assert.EqualValues(t, 4, atomic.LoadInt32(&runs), "every worker should run the broadcast exactly once")
+	config.Range(func(key, value interface{}) bool {
+		assert.EqualValuesf(t, 2, value, "worker %d should observe the update", key)
+		return true
+	})
+	assert.EqualValues(t, 4, p.Running())
+
+	p.Release()
+	assert.EqualError(t, p.Broadcast(func() {}), ErrPoolClosed.Error())
+}
+
+func TestBroadcastFromTask(t *testing.T) {
+	p, _ := NewPool(2)
+	defer p.Release()
+
+	errs := make(chan error, 1)
+	assert.NoError(t, p.Submit(func() { errs <- p.Broadcast(func() {}) }))
+	select {
+	case err := <-errs:
+		assert.EqualError(t, err, ErrBroadcastFromTask.Error(), "a task should not broadcast to its own worker")
+	case <-time.After(time.Second):
+		t.Fatal("Broadcast from a task should not deadlock")
+	}
+
+	// A deep task stack doesn't hide the worker.
+	var deep func(int) error
+	deep = func(depth int) error {
+		if depth == 0 {
+			return p.Broadcast(func() {})
+		}
+		return deep(depth - 1)
+	}
+	assert.NoError(t, p.Submit(func() { errs <- deep(200) }))
+	assert.EqualError(t, <-errs, ErrBroadcastFromTask.Error())
+
+	assert.NoError(t, p.Broadcast(func() {}), "Broadcast from outside the workers should succeed")
+
+	other, _ := NewPool(1)
+	defer other.Release()
+	assert.NoError(t, other.Submit(func() { errs <- p.Broadcast(func() {}) }))
+	select {
+	case err := <-errs:
+		assert.NoError(t, err, "Broadcast from a task of another pool should succeed")
+	case <-time.After(time.Second):
+		t.Fatal("Broadcast from a task of another pool should not deadlock")
+	}
+}
+
+func TestReleaseDrainQueue(t *testing.T) {
+	p, _ := NewPool(2)
+
+	var ran int32
+	task := func() {
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&ran, 1)
+	}
+	_ = p.Submit(task)
+	_ = p.Submit(task)
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, p.Submit(task), "the queued tasks should be accepted")
+		}()
+	}
+	assert.Eventually(t, func() bool { return p.Waiting() == 6 }, time.Second, time.Millisecond)
+	rejected := make(chan error, 1)
+	go func() {
+		for atomic.LoadInt32(&p.draining) == 0 {
+			runtime.Gosched()
+		}
+		rejected <- p.Submit(task)
+	}()
+
+	assert.NoError(t, p.ReleaseDrainQueue(3*time.Second))
+	assert.EqualValues(t, 8, atomic.LoadInt32(&ran), "all queued tasks should have run before returning")
+	assert.EqualError(t, <-rejected, ErrPoolClosed.Error(), "the new submissions should be rejected while draining")
+	assert.True(t, p.IsClosed())
+	assert.EqualError(t, p.ReleaseDrainQueue(time.Second), ErrPoolClosed.Error())
+	wg.Wait()
+
+	p.Reboot()
+	block := make(chan struct{})
+	assert.NoError(t, p.Submit(func() { <-block }), "the rebooted pool should accept the submissions")
+	_ = p.Submit(func() { <-block })
+	go func() { _ = p.Submit(task) }()
+	assert.Eventually(t, func() bool { return p.Waiting() == 1 }, time.Second, time.Millisecond)
+	assert.EqualError(t, p.ReleaseDrainQueue(50*time.Millisecond), ErrTimeout.Error())
+	assert.True(t, p.IsClosed(), "the pool should be released even if it timed out")
+	close(block)
+}
+
+func TestWithAcceptDuringDrain(t *testing.T) {
+	p, _ := NewPool(2, WithAcceptDuringDrain(true))
+
+	var ran int32
+	block := make(chan struct{})
+	assert.NoError(t, p.Submit(func() {
+		<-block
+		atomic.AddInt32(&ran, 1)
+	}))
+	drained := make(chan error, 1)
+	go func() { drained <- p.ReleaseDrainQueue(3 * time.Second) }()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&p.draining) == 1 }, time.Second, time.Millisecond)
+
+	// The free worker is given to the submissions during the drain, and the pool stays open for the running task.
+	assert.NoError(t, p.Submit(func() { atomic.AddInt32(&ran, 1) }), "the free capacity should be accepted")
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&ran) == 1 }, time.Second, time.Millisecond)
+	assert.NoError(t, p.Submit(func() {
+		<-block
+		atomic.AddInt32(&ran, 1)
+	}))
+	assert.EqualError(t, p.Submit(func() {}), ErrPoolClosed.Error(), "the submissions should not block while draining")
+	assert.False(t, p.IsClosed())
+
+	close(block)
+	assert.NoError(t, <-drained)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&ran), "the running tasks should be done before releasing")
+	assert.True(t, p.IsClosed())
+	assert.EqualError(t, p.Submit(func() {}), ErrPoolClosed.Error())
+}
+
+func TestWithCPUBound(t *testing.T) {
+	p, _ := NewPool(10, WithCPUBound(2))
+	defer p.Release()
+
+	var inCPU, peak int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		_ = p.Submit(func() {
+			defer wg.Done()
+			<-start // I/O section
+			p.RunCPUBound(func() {
+				n := atomic.AddInt32(&inCPU, 1)
+				for {
+					m := atomic.LoadInt32(&peak)
+					if n <= m || atomic.CompareAndSwapInt32(&peak, m, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				atomic.AddInt32(&inCPU, -1)
+			})
+		})
+	}
+	assert.EqualValues(t, 10, p.Running(), "all workers should be available for the I/O sections")
+	close(start)
+	wg.Wait()
+	assert.EqualValues(t, 2, atomic.LoadInt32(&peak), "the CPU-bound sections should be gated to the limit")
+}
+
+func TestSubmitAfter(t *testing.T) {
+	p, _ := NewPool(2)
+	defer p.Release()
+
+	fired := make(chan struct{})
+	var cancelledRan int32
+	_, err := p.SubmitAfter(10*time.Millisecond, func() { close(fired) })
+	assert.NoError(t, err)
+	id, _ := p.SubmitAfter(50*time.Millisecond, func() { atomic.AddInt32(&cancelledRan, 1) })
+	assert.EqualValues(t, 2, p.ScheduledCount())
+	assert.True(t, p.CancelScheduled(id), "the scheduled task should be cancelled before firing")
+	assert.False(t, p.CancelScheduled(id), "a task can only be cancelled once")
+	<-fired
+	assert.EqualValues(t, 0, p.ScheduledCount())
+	time.Sleep(100 * time.Millisecond)
+	assert.EqualValues(t, 0, atomic.LoadInt32(&cancelledRan), "the cancelled task should never run")
+
+	_, _ = p.SubmitAfter(time.Hour, func() {})
+	p.Release()
+	assert.EqualValues(t, 0, p.ScheduledCount(), "the scheduled tasks should be cancelled on release")
+	_, err = p.SubmitAfter(time.Millisecond, func() {})
+	assert.EqualError(t, err, ErrPoolClosed.Error())
+}
+
+func TestSubmitTraced(t *testing.T) {
+	p, _ := NewPool(1)
+	defer p.Release()
+
+	_ = p.Submit(func() { time.Sleep(50 * time.Millisecond) })
+	trace, err := p.SubmitTraced(func() { time.Sleep(10 * time.Millisecond) })
+	assert.NoError(t, err)
+	assert.True(t, trace.StartedAt.Sub(trace.QueuedAt) >= 40*time.Millisecond,
+		"the task should have queued for about the wait time, got %v", trace.StartedAt.Sub(trace.QueuedAt))
+	assert.True(t, trace.FinishedAt.Sub(trace.StartedAt) >= 10*time.Millisecond,
+		"the task should have executed for its run time, got %v", trace.FinishedAt.Sub(trace.StartedAt))
+
+	p.Release()
+	_, err = p.SubmitTraced(func() {})
+	assert.EqualError(t, err, ErrPoolClosed.Error())
+}
+
+func TestWithStackWarm(t *testing.T) {
+	p, _ := NewPool(10, WithStackWarm(1000), WithPreAlloc(true))
+	defer p.Release()
+	pf, _ := NewPoolWithFunc(10, demoPoolFunc, WithStackWarm(1000))
+	defer pf.Release()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		assert.NoError(t, p.Submit(wg.Done))
+		assert.NoError(t, pf.Invoke(1))
+	}
+	wg.Wait()
+	assert.NotZero(t, p.Running(), "the warmed-up workers should be ready")
+	assert.NotZero(t, pf.Running(), "the warmed-up workers should be ready")
+}
+
+func TestShrink(t *testing.T) {
+	p, _ := NewPool(10)
+	defer p.Release()
+
+	var wg sync.WaitGroup
+	block := make(chan struct{})
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		_ = p.Submit(func() {
+			wg.Done()
+			<-block
+		})
+	}
+	wg.Wait()
+	close(block)
+	assert.Eventually(t, func() bool { return p.Free() == 5 && p.Running() == 5 }, time.Second, time.Millisecond)
+	p.lock.Lock()
+	idle := p.workers.len()
+	p.lock.Unlock()
+	assert.EqualValues(t, 5, idle)
+
+	assert.EqualValues(t, 3, p.Shrink(3), "exactly 3 idle workers should be stopped")
+	assert.EqualValues(t, 2, p.Shrink(3), "only the 2 idle workers left should be stopped")
+	assert.EqualValues(t, 0, p.Shrink(3))
+	assert.Eventually(t, func() bool { return p.Running() == 0 }, time.Second, time.Millisecond)
+	assert.EqualValues(t, 10, p.Cap(), "the capacity should be unchanged")
+	assert.NoError(t, p.Submit(func() {}), "the pool should scale back up")
+
+	pf, _ := NewPoolWithFunc(4, demoPoolFunc)
+	defer pf.Release()
+	for i := 0; i < 4; i++ {
+		_ = pf.Invoke(1)
+	}
+	assert.Eventually(t, func() bool {
+		pf.lock.Lock()
+		defer pf.lock.Unlock()
+		return pf.workers.len() == 4
+	}, time.Second, time.Millisecond)
+	assert.EqualValues(t, 4, pf.Shrink(10), "no more than the idle workers should be stopped")
+}
+
+func TestWithEvents(t *testing.T) {
+	p, _ := NewPool(1, WithEvents(16))
+
+	done := make(chan struct{})
+	assert.NoError(t, p.Submit(func() { close(done) }))
+	<-done
+	assert.Eventually(t, func() bool { return len(p.Events()) == 3 }, time.Second, time.Millisecond)
+	p.Release()
+	assert.Eventually(t, func() bool { return p.Running() == 0 }, time.Second, time.Millisecond)
+
+	var events []PoolEvent
+	for len(p.Events()) > 0 {
+		events = append(events, <-p.Events())
+	}
+	assert.EqualValues(t, []PoolEvent{WorkerSpawned, TaskSubmitted, TaskCompleted, PoolReleased, WorkerStopped}, events)
+	assert.Equal(t, "TaskCompleted", TaskCompleted.String())
+
+	// The events are dropped instead of blocking the pool when the buffer is full.
+	p, _ = NewPool(1, WithEvents(1))
+	defer p.Release()
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, p.Submit(func() {}))
+	}
+	assert.Nil(t, (&Pool{}).Events(), "no event is emitted without WithEvents")
+}
+
+func TestSubmitClass(t *testing.T) {
+	p, _ := NewPool(2, WithClassWeights(map[string]int{"noisy": 1, "quiet": 2}))
+	defer p.Release()
+
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+		tasks sync.WaitGroup
+	)
+	submit := func(class string) {
+		defer wg.Done()
+		tasks.Add(1)
+		assert.NoError(t, p.SubmitClass(class, func() {
+			defer tasks.Done()
+			mu.Lock()
+			order = append(order, class)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+		}))
+	}
+	block := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = p.SubmitClass("noisy", func() { <-block })
+		}()
+	}
+	queued := func(n int) func() bool {
+		return func() bool {
+			p.classes.lock.Lock()
+			defer p.classes.lock.Unlock()
+			return p.classes.queued == n
+		}
+	}
+	assert.Eventually(t, func() bool { return p.Running() == 2 }, time.Second, time.Millisecond)
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go submit("noisy")
+	}
+	assert.Eventually(t, queued(30), time.Second, time.Millisecond)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go submit("quiet")
+	}
+	assert.Eventually(t, queued(40), time.Second, time.Millisecond)
+	close(block)
+	wg.Wait()
+	tasks.Wait()
+
+	// With the weights of 1:2, the quiet class should get about two of every three workers until it's drained,
+	// which is by the 15th task, even though all of its tasks were queued behind the noisy ones.
+	assert.Len(t, order, 40)
+	last := 0
+	for i, class := range order {
+		if class == "quiet" {
+			last = i
+		}
+	}
+	assert.Less(t, last, 20, "the quiet class should make proportional progress, got %v", order)
+
+	p.Release()
+	assert.EqualError(t, p.SubmitClass("quiet", func() {}), ErrPoolClosed.Error())
+}
+
+func TestShutdownNowClass(t *testing.T) {
+	p, _ := NewPool(1)
+	block := make(chan struct{})
+	assert.NoError(t, p.Submit(func() { <-block }))
+
+	// The class task takes the only slot, then blocks on the busy worker.
+	var ran int32
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.SubmitClass("a", func() { atomic.AddInt32(&ran, 1) })
+	}()
+	assert.Eventually(t, func() bool { return p.Waiting() == 1 }, time.Second, time.Millisecond)
+	tasks := p.ShutdownNow()
+	assert.EqualError(t, <-errCh, ErrPoolClosed.Error())
+	close(block)
+	assert.Len(t, tasks, 1)
+
+	p.Reboot()
+	defer p.Release()
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		task := task
+		wg.Add(1)
+		assert.NoError(t, p.Submit(func() {
+			defer wg.Done()
+			task()
+		}))
+	}
+	wg.Wait()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&ran), "the task as submitted should be handed back")
+	p.classes.lock.Lock()
+	inflight := p.classes.inflight
+	p.classes.lock.Unlock()
+	assert.Zero(t, inflight, "replaying the task should not give back the slot once more")
+}
+
+func TestSubmitClassSaturated(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	p, _ := NewPool(1, WithNonblocking(true))
+	defer p.Release()
+	assert.NoError(t, p.SubmitClass("a", func() { <-block }))
+	assert.EqualError(t, p.SubmitClass("a", demoFunc), ErrPoolOverload.Error(),
+		"a nonblocking pool should reject a class task without a slot")
+
+	p, _ = NewPool(1, WithMaxBlockingTasks(1))
+	defer p.Release()
+	assert.NoError(t, p.SubmitClass("a", func() { <-block }))
+	errs := make(chan error, 1)
+	var ran int32
+	go func() {
+		errs <- p.SubmitClass("b", func() { atomic.StoreInt32(&ran, 1) })
+	}()
+	assert.Eventually(t, func() bool { return p.Waiting() == 1 }, time.Second, time.Millisecond,
+		"the class waiters should be counted by Waiting")
+	assert.EqualError(t, p.SubmitClass("a", demoFunc), ErrPoolOverload.Error(),
+		"MaxBlockingTasks should bound the class waiters")
+
+	tasks := p.ShutdownNow()
+	assert.EqualError(t, <-errs, ErrPoolClosed.Error(), "the class waiters should be rejected by ShutdownNow")
+	assert.Len(t, tasks, 1, "ShutdownNow should return the tasks of the class waiters")
+	assert.Zero(t, p.Waiting())
+	tasks[0]()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&ran), "the submitted task should be returned")
+}
+
+func TestWithAgingThreshold(t *testing.T) {
+	p, _ := NewPool(1, WithClassWeights(map[string]int{"high": 1000, "low": 1}), WithAgingThreshold(20*time.Millisecond))
+	defer p.Release()
+
+	var (
+		wg   sync.WaitGroup
+		high int32
+	)
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				assert.NoError(t, p.SubmitClass("high", func() {
+					atomic.AddInt32(&high, 1)
+					time.Sleep(2 * time.Millisecond)
+				}))
+			}
+		}()
+	}
+	assert.Eventually(t, func() bool {
+		p.classes.lock.Lock()
+		defer p.classes.lock.Unlock()
+		return p.classes.queued >= 3
+	}, time.Second, time.Millisecond)
+
+	// With the weights of 1000:1 the low class would have to wait for about 500 high tasks,
+	// the aging lets it in once it's been waiting for 20ms whereas the high waiters never get that old.
+	served := make(chan struct{})
+	start := time.Now()
+	assert.NoError(t, p.SubmitClass("low", func() { close(served) }))
+	select {
+	case <-served:
+		assert.Less(t, time.Since(start), 200*time.Millisecond)
+	case <-time.After(time.Second):
+		t.Error("the aged low class should be served under the continuous high load")
+	}
+	close(stop)
+	wg.Wait()
+	assert.Greater(t, atomic.LoadInt32(&high), int32(4))
+}
+
+func TestWithSynchronous(t *testing.T) {
+	p, _ := NewPool(1, WithSynchronous(true))
+	defer p.Release()
+
+	caller := goroutineID()
+	var done bool
+	assert.NoError(t, p.Submit(func() {
+		assert.EqualValues(t, caller, goroutineID(), "the task should run on the calling goroutine")
+		assert.EqualValues(t, 1, p.Running(), "the task should be counted while it runs")
+		// A nested submission to a synchronous pool can't deadlock.
+		assert.NoError(t, p.Submit(func() {}))
+		done = true
+	}))
+	assert.True(t, done, "the task should complete before Submit returns")
+	assert.EqualValues(t, 0, p.Running())
+
+	var arg interface{}
+	pf, _ := NewPoolWithFunc(1, func(i interface{}) { arg = i }, WithSynchronous(true))
+	defer pf.Release()
+	assert.NoError(t, pf.Invoke(42))
+	assert.EqualValues(t, 42, arg, "the task should complete before Invoke returns")
+
+	p.Release()
+	assert.EqualError(t, p.Submit(func() {}), ErrPoolClosed.Error())
+}
+
+func TestWithSynchronousPanic(t *testing.T) {
+	var panics []interface{}
+	handler := WithPanicHandler(func(r interface{}) { panics = append(panics, r) })
+	p, _ := NewPool(1, WithSynchronous(true), handler)
+	defer p.Release()
+	assert.NotPanics(t, func() {
+		assert.NoError(t, p.Submit(func() { panic("submit") }))
+	}, "the panic should not escape to the caller")
+
+	pf, _ := NewPoolWithFunc(1, func(interface{}) { panic("invoke") }, WithSynchronous(true), handler)
+	defer pf.Release()
+	assert.NotPanics(t, func() {
+		assert.NoError(t, pf.Invoke(nil))
+	}, "the panic should not escape to the caller")
+	assert.Equal(t, []interface{}{"submit", "invoke"}, panics, "the panics should be passed to the panic handler")
+	assert.EqualValues(t, 0, p.Running())
+	assert.EqualValues(t, 0, pf.Running())
+}
+
+func TestTotalRejected(t *testing.T) {
+	p, _ := NewPool(2, WithNonblocking(true))
+	defer p.Release()
+
+	block := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, p.Submit(func() { <-block }))
+	}
+	for i := 0; i < 5; i++ {
+		assert.EqualError(t, p.Submit(func() {}), ErrPoolOverload.Error())
+	}
+	assert.EqualValues(t, 5, p.TotalRejected())
+	close(block)
+
+	pf, _ := NewPoolWithFunc(1, longRunningPoolFunc, WithNonblocking(true))
+	defer pf.Release()
+	pfch := make(chan struct{})
+	assert.NoError(t, pf.Invoke(pfch))
+	for i := 0; i < 3; i++ {
+		assert.EqualError(t, pf.Invoke(pfch), ErrPoolOverload.Error())
+	}
+	assert.EqualValues(t, 3, pf.TotalRejected())
+	close(pfch)
+}
+
+func TestSubmitCallback(t *testing.T) {
+	var panics int32
+	p, _ := NewPool(1, WithPanicHandler(func(interface{}) { atomic.AddInt32(&panics, 1) }))
+	defer p.Release()
+
+	var taskDone int32
+	called := make(chan bool, 1)
+	assert.NoError(t, p.SubmitCallback(func() {
+		time.Sleep(10 * time.Millisecond)
+		atomic.StoreInt32(&taskDone, 1)
+	}, func() {
+		called <- atomic.LoadInt32(&taskDone) == 1
+	}))
+	assert.True(t, <-called, "the callback should run after the task")
+
+	// A panicking callback is guarded, the worker survives it.
+	assert.NoError(t, p.SubmitCallback(func() {}, func() { panic("callback") }))
+	errs := make(chan error, 2)
+	errBoom := errors.New("boom")
+	assert.NoError(t, p.SubmitCallbackErr(func() error { return errBoom }, func(err error) { errs <- err }))
+	assert.NoError(t, p.SubmitCallbackErr(func() error { panic("task") }, func(err error) { errs <- err }))
+	assert.Equal(t, errBoom, <-errs)
+	var pe *PanicError
+	assert.True(t, errors.As(<-errs, &pe), "the panic of the task should be passed to the callback")
+	assert.EqualValues(t, "task", pe.Value)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&panics), "the panic of the callback should be handled")
+	assert.EqualValues(t, 1, p.Running(), "the worker should survive")
+}
+
+func TestWithValidate(t *testing.T) {
+	var wrapped int32
+	mw := func(next func()) func() {
+		atomic.AddInt32(&wrapped, 1)
+		return next
+	}
+	admitter := semaphoreAdmitter{sem: make(chan struct{}, 1)}
+	p, err := NewPool(1, WithValidate(true), WithLatencyTracking(true), WithEvents(8),
+		WithMiddleware(mw), WithAdmitter(admitter))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, p.Running(), "the probe worker should be ready")
+	assert.Eventually(t, func() bool { return p.busyWorkers() == 0 }, time.Second, time.Millisecond)
+	assert.Zero(t, p.TotalSubmitted(), "the probe should not be counted as a task")
+	assert.Zero(t, p.TotalCompleted(), "the probe should not be counted as a task")
+	assert.Zero(t, p.TotalExecTime(), "the probe should not be counted as a task")
+	for _, n := range p.LatencyHistogram() {
+		assert.Zero(t, n, "the probe should not be counted as a task")
+	}
+	assert.Zero(t, atomic.LoadInt32(&wrapped), "the probe should not be wrapped by the middlewares")
+	assert.Empty(t, admitter.sem, "the probe should not be admitted by the Admitter")
+	p.Release()
+	for {
+		select {
+		case event := <-p.Events():
+			assert.NotEqual(t, TaskSubmitted, event, "the probe should not emit the task events")
+			assert.NotEqual(t, TaskCompleted, event, "the probe should not emit the task events")
+			continue
+		default:
+		}
+		break
+	}
+}
+
+func TestAppendStats(t *testing.T) {
+	p, _ := NewPool(3, WithNonblocking(true))
+	defer p.Release()
+
+	block := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, p.Submit(func() { <-block }))
+	}
+	assert.EqualError(t, p.Submit(func() {}), ErrPoolOverload.Error())
+
+	buf := p.AppendStats([]byte("header"))
+	assert.Len(t, buf, len("header")+StatsSize)
+	stats, err := DecodeStats(buf[len("header"):])
+	assert.NoError(t, err)
+	assert.EqualValues(t, Stats{Capacity: 3, Running: 3, Waiting: 0, Rejected: 1}, stats)
+	close(block)
+
+	// The stats can be scraped into a reused buffer without allocations.
+	allocs := testing.AllocsPerRun(100, func() { buf = p.AppendStats(buf[:0]) })
+	assert.Zero(t, allocs)
+
+	pf, _ := NewPoolWithFunc(-1, demoPoolFunc)
+	defer pf.Release()
+	stats, err = DecodeStats(pf.AppendStats(nil))
+	assert.NoError(t, err)
+	assert.EqualValues(t, Stats{Capacity: -1}, stats)
+
+	_, err = DecodeStats(buf[:StatsSize-1])
+	assert.EqualError(t, err, ErrInvalidStats.Error())
+}
+
+func TestWithFIFOTasks(t *testing.T) {
+	p, _ := NewPool(1, WithFIFOTasks(true))
+	defer p.Release()
+
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	task := func(i int) func() {
+		return func() {
+			defer wg.Done()
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+		}
+	}
+	block := make(chan struct{})
+	assert.NoError(t, p.Submit(func() { <-block }))
+	const n = 10
+	wg.Add(n + 1)
+	for i := 0; i < n; i++ {
+		go func(i int) { assert.NoError(t, p.Submit(task(i))) }(i)
+		assert.Eventually(t, func() bool { return p.Waiting() == i+1 }, time.Second, time.Millisecond)
+	}
+	close(block)
+	// A task submitted while the queue is being drained must queue up behind the waiting ones.
+	assert.NoError(t, p.Submit(task(n)))
+	wg.Wait()
+
+	expected := make([]int, 0, n+1)
+	for i := 0; i <= n; i++ {
+		expected = append(expected, i)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.EqualValues(t, expected, order, "the tasks should start in submission order")
+}
+
+func TestSubmitBackoff(t *testing.T) {
+	logger := &recordingLogger{}
+	p, _ := NewPool(1, WithLogger(logger))
+	defer p.Release()
+
+	var attempts int32
+	succeeded := make(chan struct{})
+	policy := BackoffPolicy{MaxAttempts: 5, InitialDelay: 200 * time.Millisecond, Multiplier: 1.5}
+	assert.NoError(t, p.SubmitBackoff(func() error {
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			return errors.New("transient failure")
+		}
+		close(succeeded)
+		return nil
+	}, policy))
+
+	// The only worker is free between the attempts, so other tasks keep running during the delays.
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&attempts) == 1 }, time.Second, time.Millisecond)
+	ran := make(chan struct{})
+	assert.NoError(t, p.Submit(func() { close(ran) }))
+	select {
+	case <-ran:
+	case <-time.After(150 * time.Millisecond):
+		t.Fatal("the worker should not be held during the backoff")
+	}
+	assert.EqualValues(t, 1, atomic.LoadInt32(&attempts))
+
+	select {
+	case <-succeeded:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the task should succeed on the third attempt")
+	}
+	assert.EqualValues(t, 3, atomic.LoadInt32(&attempts))
+
+	// The error of the last attempt is logged once the attempts are exhausted.
+	policy = BackoffPolicy{MaxAttempts: 2, InitialDelay: time.Millisecond}
+	assert.NoError(t, p.SubmitBackoff(func() error { return errors.New("permanent failure") }, policy))
+	assert.Eventually(t, func() bool {
+		return logger.contains("task fails after 2 attempts: permanent failure")
+	}, time.Second, time.Millisecond)
+}
+
+func TestGoroutineCount(t *testing.T) {
+	// Let the goroutines of the pools released by the previous tests exit before taking the baseline.
+	before := runtime.NumGoroutine()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		time.Sleep(20 * time.Millisecond)
+		n := runtime.NumGoroutine()
+		if n == before {
+			break
+		}
+		before = n
+	}
+	p, _ := NewPool(10, WithDisablePurge(true))
+	assert.EqualValues(t, 1, p.GoroutineCount(), "only the ticktock goroutine should be started")
+
+	var wg sync.WaitGroup
+	block := make(chan struct{})
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		assert.NoError(t, p.Submit(func() {
+			defer wg.Done()
+			<-block
+		}))
+	}
+	assert.EqualValues(t, 6, p.GoroutineCount())
+	assert.EqualValues(t, runtime.NumGoroutine()-before, p.GoroutineCount(),
+		"the count should reconcile with runtime.NumGoroutine()")
+
+	// The workers stay around after their tasks are done until the pool is released.
+	close(block)
+	wg.Wait()
+	assert.EqualValues(t, 6, p.GoroutineCount())
+	assert.NoError(t, p.ReleaseTimeout(time.Second))
+	assert.Eventually(t, func() bool { return p.GoroutineCount() == 0 }, time.Second, time.Millisecond)
+
+	pf, _ := NewPoolWithFunc(10, longRunningPoolFunc)
+	assert.EqualValues(t, 2, pf.GoroutineCount())
+	pfch := make(chan struct{})
+	assert.NoError(t, pf.Invoke(pfch))
+	assert.EqualValues(t, 3, pf.GoroutineCount())
+	close(pfch)
+	assert.NoError(t, pf.ReleaseTimeout(time.Second))
+	assert.Eventually(t, func() bool { return pf.GoroutineCount() == 0 }, time.Second, time.Millisecond)
+
+	// The timer releasing the pool at AutoReleaseAt is counted while it fires.
+	pa, _ := NewPool(10, WithDisablePurge(true), WithAutoReleaseAt(time.Now().Add(20*time.Millisecond)))
+	fired := make(chan int, 1)
+	pa.OnRelease(func() {
+		assert.Eventually(t, func() bool { return pa.GoroutineCount() == 1 }, time.Second, time.Millisecond)
+		fired <- pa.GoroutineCount()
+	})
+	assert.EqualValues(t, 1, <-fired, "the firing timer should be counted")
+	assert.Eventually(t, func() bool { return pa.GoroutineCount() == 0 }, time.Second, time.Millisecond)
+
+	// So is the timer restoring the capacity after BoostCapacity.
+	pb, _ := NewPool(10, WithDisablePurge(true))
+	pb.BoostCapacity(5, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return pb.Cap() == 10 }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool { return pb.GoroutineCount() == 1 }, time.Second, time.Millisecond)
+	pb.Release()
+}
+
+func TestReconfigure(t *testing.T) {
+	var oldPanics, newPanics int32
+	p, _ := NewPool(4, WithPanicHandler(func(interface{}) { atomic.AddInt32(&oldPanics, 1) }))
+	defer p.Release()
+
+	// Keep the pool under load while it's reconfigured.
+	block := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, p.Submit(func() { <-block }))
+	}
+	logger := &recordingLogger{}
+	assert.NoError(t, p.Reconfigure(func(opts *Options) {
+		opts.PanicHandler = func(interface{}) { atomic.AddInt32(&newPanics, 1) }
+		opts.Logger = logger
+		opts.ExpiryDuration = 3 * time.Second
+		opts.Nonblocking = true
+	}))
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, p.Submit(func() { panic("oops") }))
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&newPanics) == 3 }, time.Second, time.Millisecond)
+	assert.Zero(t, atomic.LoadInt32(&oldPanics), "the subsequent tasks should use the new panic handler")
+	close(block)
+
+	assert.NoError(t, p.SubmitBackoff(func() error { return errors.New("failure") }, BackoffPolicy{MaxAttempts: 1}))
+	assert.Eventually(t, func() bool { return logger.contains("task fails after 1 attempts") }, time.Second, time.Millisecond)
+
+	clone, _ := p.Clone()
+	defer clone.Release()
+	assert.EqualValues(t, 3*time.Second, clone.options.ExpiryDuration, "the clone should take the new settings")
+	assert.False(t, clone.options.Nonblocking, "the options that can't be changed on a running pool should be ignored")
+
+	assert.EqualError(t, p.Reconfigure(func(opts *Options) { opts.ExpiryDuration = -1 }), ErrInvalidPoolExpiry.Error())
+	assert.EqualValues(t, 3*time.Second, p.hotOptions().expiry)
+}
+
+func TestSubmitReserving(t *testing.T) {
+	p, _ := NewPool(4)
+	defer p.Release()
+
+	var wg sync.WaitGroup
+	block := make(chan struct{})
+	task := func() {
+		defer wg.Done()
+		<-block
+	}
+	wg.Add(2)
+	assert.NoError(t, p.SubmitReserving(task, 2))
+	assert.NoError(t, p.SubmitReserving(task, 2))
+	assert.EqualError(t, p.SubmitReserving(task, 2), ErrInsufficientHeadroom.Error(),
+		"the submission should be refused once the free capacity would drop below the reserve")
+	assert.EqualValues(t, 2, p.Running())
+
+	// The reserved workers are still given to the regular submissions.
+	wg.Add(2)
+	assert.NoError(t, p.Submit(task))
+	assert.NoError(t, p.SubmitReserving(task, 0))
+	assert.EqualError(t, p.SubmitReserving(func() {}, 0), ErrInsufficientHeadroom.Error())
+	close(block)
+	wg.Wait()
+
+	// The idle workers count as available.
+	assert.Eventually(t, func() bool { return p.busyWorkers() == 0 }, time.Second, time.Millisecond)
+	assert.EqualValues(t, 4, p.Running())
+	wg.Add(1)
+	assert.NoError(t, p.SubmitReserving(func() { wg.Done() }, 3))
+	wg.Wait()
+
+	unlimited, _ := NewPool(-1)
+	defer unlimited.Release()
+	assert.NoError(t, unlimited.SubmitReserving(func() {}, 100))
+
+	p.Release()
+	assert.EqualError(t, p.SubmitReserving(func() {}, 0), ErrPoolClosed.Error())
+}
+
+func TestForEachWorker(t *testing.T) {
+	p, _ := NewPool(4)
+	defer p.Release()
+
+	block := make(chan struct{})
+	assert.NoError(t, p.SubmitTagged("long", func() { <-block }))
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		assert.NoError(t, p.Submit(wg.Done))
+	}
+	wg.Wait()
+	assert.Eventually(t, func() bool { return p.busyWorkers() == 1 }, time.Second, time.Millisecond)
+
+	var (
+		busy   []WorkerInfo
+		idle   []WorkerInfo
+		served uint64
+	)
+	ids := make(map[uint64]struct{})
+	p.ForEachWorker(func(info WorkerInfo) {
+		ids[info.ID] = struct{}{}
+		assert.Greater(t, int64(info.Age), int64(0))
+		if info.Busy {
+			busy = append(busy, info)
+		} else {
+			idle = append(idle, info)
+			served += info.Served
+		}
+	})
+	assert.Len(t, ids, p.Running(), "each worker should be reported once with a unique ID")
+	if assert.Len(t, busy, 1) {
+		assert.Equal(t, "long", busy[0].Tag, "the busy worker should report the tag of its task")
+		assert.Zero(t, busy[0].Served)
+	}
+	assert.NotEmpty(t, idle)
+	for _, info := range idle {
+		assert.Empty(t, info.Tag)
+	}
+	assert.EqualValues(t, 3, served)
+
+	// The tag is cleared once the task is done.
+	close(block)
+	assert.Eventually(t, func() bool { return p.busyWorkers() == 0 }, time.Second, time.Millisecond)
+	p.ForEachWorker(func(info WorkerInfo) {
+		assert.False(t, info.Busy)
+		assert.Empty(t, info.Tag)
+	})
+}
+
+func TestTagClearedWithoutReuse(t *testing.T) {
+	p, _ := NewPool(1, WithNoReuse(true))
+	defer p.Release()
+
+	done := make(chan struct{})
+	assert.NoError(t, p.SubmitTagged("once", func() { close(done) }))
+	<-done
+	assert.Eventually(t, func() bool { return p.Running() == 0 }, time.Second, time.Millisecond)
+
+	// The next worker is likely to be recycled from the cache, it must not report the stale tag.
+	block := make(chan struct{})
+	defer close(block)
+	assert.NoError(t, p.Submit(func() { <-block }))
+	p.ForEachWorker(func(info WorkerInfo) {
+		assert.Empty(t, info.Tag, "an untagged task should not report the tag of a previous task")
+	})
+}
+
+func TestWithDynamicCapacity(t *testing.T) {
+	var capacity, calls int32 = 2, 0
+	p, _ := NewPool(10, WithNonblocking(true), WithDynamicCapacity(func() int {
+		atomic.AddInt32(&calls, 1)
+		return int(atomic.LoadInt32(&capacity))
+	}))
+	defer p.Release()
+	assert.EqualValues(t, 2, p.Cap(), "the initial capacity should come from the function")
+
+	block := make(chan struct{})
+	defer close(block)
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, p.Submit(func() { <-block }))
+	}
+	assert.EqualError(t, p.Submit(func() {}), ErrPoolOverload.Error())
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls), "the capacity should be cached between the submissions")
+
+	// The admission follows the function once the cached value expires.
+	atomic.StoreInt32(&capacity, 3)
+	time.Sleep(dynamicCapacityTTL + 10*time.Millisecond)
+	assert.NoError(t, p.Submit(func() { <-block }))
+	assert.EqualValues(t, 3, p.Cap())
+	assert.EqualError(t, p.Submit(func() {}), ErrPoolOverload.Error())
+
+	atomic.StoreInt32(&capacity, 1)
+	time.Sleep(dynamicCapacityTTL + 10*time.Millisecond)
+	assert.EqualError(t, p.Submit(func() {}), ErrPoolOverload.Error())
+	assert.EqualValues(t, 1, p.Cap())
+}
+
+func TestQuiesce(t *testing.T) {
+	p, _ := NewPool(2)
+	defer p.Release()
+
+	var ran int32
+	block := make(chan struct{})
+	task := func() {
+		<-block
+		atomic.AddInt32(&ran, 1)
+	}
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, p.Submit(task))
+	}
+	go func() { assert.NoError(t, p.Submit(task), "the queued task should be accepted") }()
+	assert.Eventually(t, func() bool { return p.Waiting() == 1 }, time.Second, time.Millisecond)
+
+	quiesced := make(chan error, 1)
+	go func() { quiesced <- p.Quiesce(0, 3*time.Second) }()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&p.draining) == 2 }, time.Second, time.Millisecond)
+	assert.EqualError(t, p.Submit(func() {}), ErrPoolQuiesced.Error(), "the new submissions should be rejected")
+	select {
+	case <-quiesced:
+		t.Fatal("Quiesce should block while the tasks are running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(block)
+	assert.NoError(t, <-quiesced)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&ran), "all tasks should have finished")
+	assert.False(t, p.IsClosed())
+
+	// The pool remains operable afterwards.
+	done := make(chan struct{})
+	assert.NoError(t, p.Submit(func() { close(done) }))
+	<-done
+
+	hold := make(chan struct{})
+	assert.NoError(t, p.Submit(func() { <-hold }))
+	assert.EqualError(t, p.Quiesce(0, 50*time.Millisecond), ErrTimeout.Error())
+	assert.NoError(t, p.Quiesce(1, time.Second), "one running task should meet the target")
+	close(hold)
+	assert.NoError(t, p.Submit(func() {}), "the submissions should be resumed after timing out")
+}
+
+func TestPoolWaitGroup(t *testing.T) {
+	p, _ := NewPool(2)
+	defer p.Release()
+
+	// A task of the pool outside the group mustn't hold up Wait.
+	block := make(chan struct{})
+	defer close(block)
+	assert.NoError(t, p.Submit(func() { <-block }))
+
+	g := p.NewWaitGroup()
+	var done int32
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, g.Add(func() {
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&done, 1)
+		}))
+	}
+	g.Wait()
+	assert.EqualValues(t, 10, atomic.LoadInt32(&done), "Wait should return after all tasks of the group finish")
+
+	p.Release()
+	assert.EqualError(t, g.Add(func() {}), ErrPoolClosed.Error())
+	g.Wait()
+}
+
+func TestWithMaxQueueLatency(t *testing.T) {
+	breaches := make(chan time.Duration, 1)
+	p, _ := NewPool(1, WithMaxQueueLatency(50*time.Millisecond, func(waited time.Duration) { breaches <- waited }))
+	defer p.Release()
+
+	assert.NoError(t, p.Submit(func() {}))
+	assert.Zero(t, p.SLABreaches(), "a task taking a worker at once meets the target")
+
+	block := make(chan struct{})
+	assert.NoError(t, p.Submit(func() { <-block }))
+	time.AfterFunc(100*time.Millisecond, func() { close(block) })
+	assert.NoError(t, p.Submit(func() {}), "the task should wait for the only worker")
+	assert.EqualValues(t, 1, p.SLABreaches())
+	select {
+	case waited := <-breaches:
+		assert.GreaterOrEqual(t, int64(waited), int64(50*time.Millisecond))
+	default:
+		t.Fatal("the breach should be passed to the callback")
+	}
+}
+
+func TestBoostCapacity(t *testing.T) {
+	p, _ := NewPool(2, WithNonblocking(true))
+	defer p.Release()
+
+	p.BoostCapacity(3, 100*time.Millisecond)
+	assert.EqualValues(t, 5, p.Cap())
+	block := make(chan struct{})
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, p.Submit(func() { <-block }), "the boosted capacity should be available")
+	}
+	close(block)
+
+	// The overlapping boosts compose and each of them reverts on its own.
+	p.BoostCapacity(1, 300*time.Millisecond)
+	assert.EqualValues(t, 6, p.Cap())
+	assert.Eventually(t, func() bool { return p.Cap() == 3 }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool { return p.Cap() == 2 }, time.Second, time.Millisecond)
+
+	unlimited, _ := NewPool(-1)
+	defer unlimited.Release()
+	unlimited.BoostCapacity(3, time.Millisecond)
+	assert.EqualValues(t, -1, unlimited.Cap())
+}
+
+func TestSharedWorkers(t *testing.T) {
+	s, _ := NewSharedWorkers(2, map[string]int{"api": 2, "batch": 1})
+	defer s.Release()
+	api, batch := s.Queue("api"), s.Queue("batch")
+	assert.Same(t, api, s.Queue("api"))
+
+	var (
+		mu  sync.Mutex
+		ids = make(map[uint64]struct{})
+	)
+	block := make(chan struct{})
+	task := func() {
+		mu.Lock()
+		ids[goroutineID()] = struct{}{}
+		mu.Unlock()
+		<-block
+	}
+	assert.NoError(t, api.Submit(task))
+	assert.NoError(t, batch.Submit(task))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, batch.Submit(task))
+	}()
+	assert.Eventually(t, func() bool { return batch.Waiting() == 1 }, time.Second, time.Millisecond)
+
+	// Both queues run on the same two workers, while reporting their own stats.
+	assert.EqualValues(t, 2, s.Running())
+	assert.EqualValues(t, 1, api.Running())
+	assert.EqualValues(t, 1, batch.Running())
+	assert.Zero(t, api.Waiting())
+
+	close(block)
+	wg.Wait()
+	assert.Eventually(t, func() bool { return api.Running() == 0 && batch.Running() == 0 }, time.Second, time.Millisecond)
+	mu.Lock()
+	assert.LessOrEqual(t, len(ids), 2, "the queues should share the underlying workers")
+	mu.Unlock()
+
+	s.Release()
+	assert.EqualError(t, api.Submit(func() {}), ErrPoolClosed.Error())
+}
+
+func TestSharedWorkersNonblocking(t *testing.T) {
+	s, _ := NewSharedWorkers(1, nil, WithNonblocking(true))
+	defer s.Release()
+	block := make(chan struct{})
+	defer close(block)
+	q := s.Queue("api")
+	assert.NoError(t, q.Submit(func() { <-block }))
+	assert.EqualError(t, q.Submit(demoFunc), ErrPoolOverload.Error(), "saturated nonblocking workers should reject the task")
+	assert.Zero(t, q.Waiting())
+}
+
+func TestCancelAll(t *testing.T) {
+	p, _ := NewPool(5)
+	defer p.Release()
+
+	var (
+		wg        sync.WaitGroup
+		cancelled int32
+		started   int32
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		assert.NoError(t, p.SubmitWithContext(context.Background(), func(ctx context.Context) {
+			defer wg.Done()
+			atomic.AddInt32(&started, 1)
+			select {
+			case <-ctx.Done():
+				atomic.AddInt32(&cancelled, 1)
+			case <-time.After(10 * time.Second):
+			}
+		}))
+	}
+	// A task ignoring its context keeps running.
+	ignored := make(chan struct{})
+	assert.NoError(t, p.SubmitWithContext(context.Background(), func(context.Context) { <-ignored }))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&started) == 4 }, time.Second, time.Millisecond)
+
+	p.CancelAll()
+	wg.Wait()
+	assert.EqualValues(t, 4, atomic.LoadInt32(&cancelled), "the context-aware tasks should observe the cancellation")
+	assert.EqualValues(t, 5, p.Running())
+	close(ignored)
+
+	// The context of a task is also derived from the context it's submitted with.
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	assert.NoError(t, p.SubmitWithContext(ctx, func(ctx context.Context) {
+		<-ctx.Done()
+		errs <- ctx.Err()
+	}))
+	cancel()
+	assert.Equal(t, context.Canceled, <-errs)
+}
+
+func TestPoolConfig(t *testing.T) {
+	p, _ := NewPool(3, WithExpiryDuration(2*time.Second), WithNonblocking(true),
+		WithClassWeights(map[string]int{"a": 2}), WithLatencyTracking(true), WithExecTimeTracking(true))
+	defer p.Release()
+	p.Tune(2)
+
+	data, err := json.Marshal(p.Config())
+	assert.NoError(t, err)
+	var cfg Config
+	assert.NoError(t, json.Unmarshal(data, &cfg))
+	assert.Equal(t, p.Config(), cfg, "the config should survive serialization")
+	assert.EqualValues(t, 2, cfg.Capacity, "the config should reflect the tuned capacity")
+
+	replica, err := NewPoolFromConfig(cfg)
+	assert.NoError(t, err)
+	defer replica.Release()
+	assert.Equal(t, cfg, replica.Config())
+	block := make(chan struct{})
+	defer close(block)
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, replica.Submit(func() { <-block }))
+	}
+	assert.EqualError(t, replica.Submit(func() {}), ErrPoolOverload.Error(), "the replica should be nonblocking too")
+	assert.NotNil(t, replica.LatencyHistogram())
+
+	// The options out of Config can be applied on top of it.
+	cfg.Nonblocking = false
+	cfg.MaxBlockingTasks = 1
+	blocking, _ := NewPoolFromConfig(cfg, WithPanicHandler(func(interface{}) {}))
+	defer blocking.Release()
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, blocking.Submit(func() { <-block }))
+	}
+	go func() { _ = blocking.Submit(func() {}) }()
+	assert.Eventually(t, func() bool { return blocking.Waiting() == 1 }, time.Second, time.Millisecond)
+	assert.EqualError(t, blocking.Submit(func() {}), ErrPoolOverload.Error(), "MaxBlockingTasks should be restored")
+	assert.EqualValues(t, 2*time.Second, blocking.Config().ExpiryDuration)
+	assert.NotNil(t, blocking.options.PanicHandler)
+}
+
+func TestOnRelease(t *testing.T) {
+	p, _ := NewPool(2, WithPanicHandler(func(interface{}) {}))
+	var (
+		order []int
+		done  int32
+	)
+	p.OnRelease(func() { order = append(order, 1) })
+	p.OnRelease(func() { panic("hook") })
+	p.OnRelease(func() {
+		assert.EqualValues(t, 1, atomic.LoadInt32(&done), "hooks should run after the drain")
+		order = append(order, 2)
+	})
+	_ = p.Submit(func() {
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt32(&done, 1)
+	})
+	assert.NoError(t, p.ReleaseTimeout(time.Second))
+	assert.EqualValues(t, []int{1, 2}, order, "hooks should run in order despite the panic")
+
+	p.Release()
+	assert.EqualValues(t, []int{1, 2}, order, "hooks should run only once")
+}
+
+func TestSubmitYielding(t *testing.T) {
+	// Under FIFOTasks, the rest of the steps can't take the worker ahead of the waiting tasks.
+	p, _ := NewPool(1, WithFIFOTasks(true))
+	defer p.Release()
+
+	var (
+		mu      sync.Mutex
+		order   []string
+		started = make(chan struct{})
+		proceed = make(chan struct{})
+		wg      sync.WaitGroup
+	)
+	record := func(s string) {
+		mu.Lock()
+		order = append(order, s)
+		mu.Unlock()
+	}
+	steps := 0
+	wg.Add(1)
+	assert.NoError(t, p.SubmitYielding(func() bool {
+		if steps == 0 {
+			close(started)
+			<-proceed
+		}
+		time.Sleep(10 * time.Millisecond)
+		steps++
+		record("long")
+		if steps == 5 {
+			wg.Done()
+			return true
+		}
+		return false
+	}))
+	<-started
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			_ = p.Submit(func() {
+				record("short")
+				wg.Done()
+			})
+		}()
+	}
+	assert.Eventually(t, func() bool { return p.Waiting() == 3 }, time.Second, time.Millisecond)
+	close(proceed)
+	wg.Wait()
+
+	assert.Equal(t, []string{"long", "short", "short", "short", "long", "long", "long", "long"}, order,
+		"the long task should yield to the waiting tasks after its first step")
+	assert.Equal(t, 5, steps)
+}
+
+func TestSubmitYieldingOnce(t *testing.T) {
+	// The rest of the steps are resumed without being submitted again.
+	var wrapped int32
+	p, _ := NewPool(1, WithMiddleware(func(next func()) func() {
+		atomic.AddInt32(&wrapped, 1)
+		return next
+	}))
+	defer p.Release()
+
+	var (
+		steps   int32
+		started = make(chan struct{})
+		proceed = make(chan struct{})
+		done    = make(chan struct{})
+	)
+	assert.NoError(t, p.SubmitYielding(func() bool {
+		if atomic.AddInt32(&steps, 1) == 1 {
+			close(started)
+			<-proceed
+			return false
+		}
+		if atomic.LoadInt32(&steps) == 5 {
+			close(done)
+			return true
+		}
+		return false
+	}))
+	<-started
+	go func() { _ = p.Submit(func() {}) }()
+	assert.Eventually(t, func() bool { return p.Waiting() == 1 }, time.Second, time.Millisecond)
+	close(proceed)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the rest of the steps should be resumed")
+	}
+	assert.Eventually(t, func() bool { return p.TotalCompleted() == 2 }, time.Second, time.Millisecond)
+	assert.EqualValues(t, 2, p.TotalSubmitted())
+	assert.EqualValues(t, 2, atomic.LoadInt32(&wrapped), "the middlewares should wrap the yielding task once")
+	time.Sleep(10 * time.Millisecond)
+	assert.EqualValues(t, 2, p.TotalCompleted())
+
+	// The rest of the steps are dropped once the pool is closed.
+	p2, _ := NewPool(1)
+	var steps2 int32
+	started, proceed = make(chan struct{}), make(chan struct{})
+	assert.NoError(t, p2.SubmitYielding(func() bool {
+		if atomic.AddInt32(&steps2, 1) == 1 {
+			close(started)
+			<-proceed
+		}
+		return false
+	}))
+	<-started
+	blocked := make(chan struct{})
+	go func() { _ = p2.Submit(func() { <-blocked }) }()
+	assert.Eventually(t, func() bool { return p2.Waiting() == 1 }, time.Second, time.Millisecond)
+	close(proceed)
+	assert.Eventually(t, func() bool { return p2.Waiting() == 0 }, time.Second, time.Millisecond)
+	p2.Release()
+	close(blocked)
+	assert.Eventually(t, func() bool { return p2.Running() == 0 }, time.Second, time.Millisecond)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&steps2), "the rest of the steps should be dropped")
+}
+
+func TestWithSpawnRate(t *testing.T) {
+	p, _ := NewPool(10, WithSpawnRate(50))
+	defer p.Release()
+
+	var (
+		mu     sync.Mutex
+		starts []time.Time
+		wg     sync.WaitGroup
+	)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			_ = p.Submit(func() {
+				mu.Lock()
+				starts = append(starts, time.Now())
+				mu.Unlock()
+				time.Sleep(time.Second)
+				wg.Done()
+			})
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, starts, 10)
+	last := starts[0]
+	for _, s := range starts {
+		if s.After(last) {
+			last = s
+		}
+	}
+	// 10 workers at 50 per second take at least 180ms to be spawned, the first one being spawned at once.
+	assert.GreaterOrEqual(t, int64(last.Sub(start)), int64(170*time.Millisecond), "spawning should be paced")
+	assert.EqualValues(t, 10, p.Running())
+
+	assert.Eventually(t, func() bool { return p.busyWorkers() == 0 }, time.Second, time.Millisecond)
+	ok, err := p.SubmitIfAvailable(func() {})
+	assert.NoError(t, err)
+	assert.True(t, ok, "an idle worker should be taken regardless of the rate")
+}
+
+func TestWithSpawnRateShutdownNow(t *testing.T) {
+	p, _ := NewPool(10, WithSpawnRate(5))
+	block := make(chan struct{})
+	defer close(block)
+	assert.NoError(t, p.Submit(func() { <-block }), "the first worker should be spawned at once")
+
+	var ran int32
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Submit(func() { atomic.StoreInt32(&ran, 1) })
+	}()
+	// The capacity is held for the worker waiting for its turn.
+	assert.Eventually(t, func() bool { return p.Running() == 2 }, time.Second, time.Millisecond)
+	tasks := p.ShutdownNow()
+	assert.Len(t, tasks, 1, "the task waiting for its turn should be handed back")
+	assert.EqualError(t, <-errCh, ErrPoolClosed.Error())
+	time.Sleep(10 * time.Millisecond)
+	assert.Zero(t, atomic.LoadInt32(&ran), "the task should not run on a closed pool")
+}
+
+func TestWithSpawnRateNonblocking(t *testing.T) {
+	p, _ := NewPool(10, WithSpawnRate(1), WithNonblocking(true))
+	defer p.Release()
+
+	block := make(chan struct{})
+	defer close(block)
+	assert.NoError(t, p.Submit(func() { <-block }), "the first worker should be spawned at once")
+	start := time.Now()
+	assert.EqualError(t, p.Submit(func() { <-block }), ErrPoolOverload.Error(),
+		"a nonblocking submission should not wait for the turn of a new worker")
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+	assert.EqualValues(t, 1, p.Running())
+}
+
+func TestRebootCounters(t *testing.T) {
+	for _, reset := range []bool{false, true} {
+		p, _ := NewPool(2, WithResetCountersOnReboot(reset), WithLatencyTracking(true))
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			_ = p.Submit(func() {
+				time.Sleep(10 * time.Millisecond)
+				wg.Done()
+			})
+		}
+		wg.Wait()
+		assert.NoError(t, p.ReleaseTimeout(time.Second))
+		assert.EqualValues(t, 4, p.TotalSubmitted())
+		assert.EqualValues(t, 4, p.TotalCompleted())
+		assert.EqualValues(t, 2, p.PeakRunning())
+		assert.NotZero(t, p.TotalExecTime())
+
+		observed := func() (n uint64) {
+			for _, count := range p.LatencyHistogram() {
+				n += count
+			}
+			return
+		}
+		p.Reboot()
+		if reset {
+			assert.EqualValues(t, 0, p.TotalSubmitted(), "counters should be reset")
+			assert.EqualValues(t, 0, p.TotalCompleted(), "counters should be reset")
+			assert.EqualValues(t, 0, p.PeakRunning(), "counters should be reset")
+			assert.Zero(t, p.TotalExecTime(), "counters should be reset")
+			assert.Zero(t, observed(), "counters should be reset")
+		} else {
+			assert.EqualValues(t, 4, p.TotalSubmitted(), "counters should carry over")
+			assert.EqualValues(t, 4, p.TotalCompleted(), "counters should carry over")
+			assert.EqualValues(t, 2, p.PeakRunning(), "counters should carry over")
+			assert.NotZero(t, p.TotalExecTime(), "counters should carry over")
+			assert.EqualValues(t, 4, observed(), "counters should carry over")
+		}
+		p.Release()
+	}
+}
+
+// consecutiveBreaker opens after threshold consecutive failures, and half-opens after coolDown.
+type consecutiveBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	coolDown  time.Duration
+	failures  int
+	openedAt  time.Time
+}
+
+func (b *consecutiveBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures < b.threshold || time.Since(b.openedAt) >= b.coolDown
+}
+
+func (b *consecutiveBreaker) Report(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if success {
+		b.failures = 0
+		return
+	}
+	if b.failures++; b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+func TestWithCircuitBreaker(t *testing.T) {
+	cb := &consecutiveBreaker{threshold: 3, coolDown: 50 * time.Millisecond}
+	p, _ := NewPool(2, WithCircuitBreaker(cb))
+	defer p.Release()
+
+	errDownstream := errors.New("downstream")
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, errDownstream, p.SubmitSafe(func() error { return errDownstream }))
+	}
+	assert.Equal(t, ErrCircuitOpen, p.Submit(demoFunc), "the submissions should be rejected while the breaker is open")
+	assert.Equal(t, ErrCircuitOpen, p.SubmitSafe(func() error { return nil }))
+
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, p.SubmitSafe(func() error { return nil }), "the submissions should be allowed once half-open")
+	assert.NoError(t, p.Submit(demoFunc), "a success should close the breaker")
+}
+
+func TestWithAutoReleaseAt(t *testing.T) {
+	start := time.Now()
+	p, _ := NewPool(2, WithAutoReleaseAt(start.Add(100*time.Millisecond)))
+	assert.NoError(t, p.Submit(demoFunc))
+	assert.False(t, p.IsClosed())
+	assert.Eventually(t, p.IsClosed, time.Second, time.Millisecond, "the pool should release itself")
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(100*time.Millisecond))
+	assert.EqualError(t, p.Submit(demoFunc), ErrPoolClosed.Error())
+
+	p, _ = NewPool(2, WithAutoReleaseAt(time.Now().Add(50*time.Millisecond)))
+	p.Release()
+	assert.Nil(t, p.autoRelease, "the timer should be stopped by an earlier release")
+	p.Reboot()
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, p.IsClosed(), "a rebooted pool should not be released at the time")
+	p.Release()
+
+	// A past time releases the pool at once, which races with the construction of the pool.
+	p, _ = NewPool(2, WithAutoReleaseAt(time.Now().Add(-time.Second)))
+	assert.Eventually(t, p.IsClosed, time.Second, time.Millisecond, "the pool should release itself at once")
+}
+
+func TestWouldBlock(t *testing.T) {
+	p, _ := NewPool(1)
+	defer p.Release()
+	assert.False(t, p.WouldBlock(), "a worker can be spawned")
+
+	block := make(chan struct{})
+	assert.NoError(t, p.Submit(func() { <-block }))
+	assert.True(t, p.WouldBlock(), "the pool is saturated")
+
+	close(block)
+	assert.Eventually(t, func() bool { return !p.WouldBlock() }, time.Second, time.Millisecond,
+		"the worker should be idle once the task finishes")
+
+	p.Release()
+	assert.False(t, p.WouldBlock(), "a closed pool never blocks")
+}
+
+func TestShutdownNowPersist(t *testing.T) {
+	p, _ := NewPool(1, WithMemoryGuard(1<<20, func(func()) uint64 { return 10 }))
+	ch := make(chan struct{})
+	_ = p.Submit(func() { <-ch })
+
+	var wg sync.WaitGroup
+	marks := make([]int32, 3)
+	for i := range marks {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = p.Submit(func() { atomic.StoreInt32(&marks[i], 1) })
+		}()
+	}
+	assert.Eventually(t, func() bool { return p.Waiting() == len(marks) }, time.Second, time.Millisecond)
+
+	errSink := errors.New("disk full")
+	var persisted []func()
+	err := p.ShutdownNowPersist(func(task func()) error {
+		persisted = append(persisted, task)
+		if len(persisted) == 2 {
+			return errSink
+		}
+		return nil
+	})
+	assert.Equal(t, errSink, err, "the first error from the sink should be returned")
+	assert.True(t, p.IsClosed())
+	assert.Len(t, persisted, len(marks), "every unstarted task should be handed to the sink")
+	wg.Wait()
+
+	close(ch)
+	assert.Eventually(t, func() bool { return atomic.LoadUint64(&p.inflightBytes) == 0 }, time.Second, time.Millisecond)
+	for _, task := range persisted {
+		task()
+	}
+	for i := range marks {
+		assert.EqualValuesf(t, 1, marks[i], "task %d should be handed to the sink", i)
+	}
+	assert.EqualValues(t, 0, atomic.LoadUint64(&p.inflightBytes), "the sink should get the submitted tasks")
+	assert.NoError(t, p.ShutdownNowPersist(func(func()) error { return errSink }), "a closed pool has nothing to persist")
+}
+
+func TestWithMemoryPressureLimit(t *testing.T) {
+	p, _ := NewPool(2, WithMemoryPressureLimit(1))
+	defer p.Release()
+	err := p.Submit(demoFunc)
+	var mpe *PoolMemoryPressureError
+	assert.True(t, errors.As(err, &mpe), "the submission should be rejected under memory pressure")
+	assert.EqualValues(t, 1, mpe.Limit)
+	assert.Greater(t, mpe.HeapAlloc, uint64(1))
+
+	p, _ = NewPool(2, WithMemoryPressureLimit(1<<40))
+	defer p.Release()
+	assert.NoError(t, p.Submit(demoFunc), "the submission should be accepted below the limit")
+}
+
+func TestCancelGroup(t *testing.T) {
+	p, _ := NewPool(4)
+	defer p.Release()
+
+	g := p.NewCancelGroup(context.Background())
+	errFailed := errors.New("failed")
+	var cancelled int32
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, g.Go(func(ctx context.Context) error {
+			select {
+			case <-ctx.Done():
+				atomic.AddInt32(&cancelled, 1)
+				return ctx.Err()
+			case <-time.After(time.Second):
+				return nil
+			}
+		}))
+	}
+	assert.NoError(t, g.Go(func(context.Context) error {
+		time.Sleep(10 * time.Millisecond)
+		return errFailed
+	}))
+	start := time.Now()
+	assert.Equal(t, errFailed, g.Wait(), "the first error should be returned")
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond), "the others should give up early")
+	assert.EqualValues(t, 3, atomic.LoadInt32(&cancelled), "the others should observe the cancellation")
+
+	g = p.NewCancelGroup(context.Background())
+	assert.NoError(t, g.Go(func(context.Context) error { return nil }))
+	assert.NoError(t, g.Wait())
+}
+
+func TestSubmitAdmission(t *testing.T) {
+	p, _ := NewPool(1)
+	defer p.Release()
+
+	result, err := p.SubmitAdmission(demoFunc)
+	assert.NoError(t, err)
+	assert.Equal(t, Admitted, result, "a worker is available at once")
+
+	block := make(chan struct{})
+	assert.NoError(t, p.Submit(func() { <-block }))
+	results := make(chan AdmissionResult, 1)
+	go func() {
+		result, _ := p.SubmitAdmission(demoFunc)
+		results <- result
+	}()
+	assert.Eventually(t, func() bool { return p.Waiting() == 1 }, time.Second, time.Millisecond)
+	close(block)
+	assert.Equal(t, Queued, <-results, "the task waited for a worker")
+
+	block = make(chan struct{})
+	defer close(block)
+	assert.NoError(t, p.Submit(func() { <-block }))
+	errs := make(chan error, 1)
+	go func() {
+		result, err := p.SubmitAdmission(demoFunc)
+		results <- result
+		errs <- err
+	}()
+	assert.Eventually(t, func() bool { return p.Waiting() == 1 }, time.Second, time.Millisecond)
+	p.Release()
+	assert.Equal(t, Blocked, <-results, "the pool was closed during the wait")
+	assert.EqualError(t, <-errs, ErrPoolClosed.Error())
+
+	result, err = p.SubmitAdmission(demoFunc)
+	assert.EqualError(t, err, ErrPoolClosed.Error())
+	assert.Equal(t, Rejected, result, "a closed pool rejects the task")
+
+	nonblocking, _ := NewPool(1, WithNonblocking(true))
+	defer nonblocking.Release()
+	assert.NoError(t, nonblocking.Submit(func() { <-block }))
+	result, err = nonblocking.SubmitAdmission(demoFunc)
+	assert.EqualError(t, err, ErrPoolOverload.Error())
+	assert.Equal(t, Rejected, result, "a saturated nonblocking pool rejects the task")
+	assert.Equal(t, "Rejected", result.String())
+}
+
+func TestDetectReentrantDeadlock(t *testing.T) {
+	p, _ := NewPool(2)
+	defer p.Release()
+
+	block := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		assert.NoError(t, p.Submit(func() {
+			defer wg.Done()
+			<-block
+		}))
+	}
+	assert.False(t, p.DetectReentrantDeadlock(), "the saturated pool isn't deadlocked")
+	close(block)
+	wg.Wait()
+
+	var entered, errs sync.WaitGroup
+	entered.Add(2)
+	errs.Add(2)
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, p.Submit(func() {
+			defer errs.Done()
+			entered.Done()
+			entered.Wait()
+			// Both workers are busy, so both re-entrant submissions block forever.
+			assert.EqualError(t, p.Submit(demoFunc), ErrPoolClosed.Error())
+		}))
+	}
+	assert.Eventually(t, p.DetectReentrantDeadlock, time.Second, 10*time.Millisecond, "the deadlock should be detected")
+
+	// Break the deadlock by releasing the pool, which wakes the blocked submissions up.
+	p.Release()
+	errs.Wait()
+	assert.False(t, p.DetectReentrantDeadlock())
+}
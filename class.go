@@ -0,0 +1,196 @@
+package ants
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// classScheduler distributes the workers of a saturated pool across the task classes submitted by
+// Pool.SubmitClass in proportion to their weights, rather than in the order the submitters wake up.
+//
+// A class task holds a slot from being dispatched until it completes, there are as many slots as
+// the capacity of the pool. When no slot is left, the submitters wait in the queues of their classes,
+// and a completing task hands its slot over to the waiter of the class picked by the smooth weighted
+// round-robin, so that the distribution is smooth as well as proportional. Under AgingThreshold,
+// the oldest waiter blocked beyond the threshold is served first regardless of the weights.
+type classScheduler struct {
+	lock     sync.Mutex
+	pool     *Pool
+	classes  map[string]*taskClass
+	inflight int
+	queued   int
+}
+
+// taskClass is the queue of the submitters waiting for a slot of one class.
+type taskClass struct {
+	weight  int
+	current int
+	waiters *list.List
+}
+
+// classWaiter is a submitter waiting for a slot, ready is closed once the slot is handed over,
+// or once the pool is closed, in which case rejected is set and the submission fails with ErrPoolClosed.
+type classWaiter struct {
+	ready    chan struct{}
+	since    time.Time
+	task     func()
+	rejected bool
+}
+
+func newClassScheduler(p *Pool) *classScheduler {
+	return &classScheduler{pool: p, classes: make(map[string]*taskClass)}
+}
+
+// submit dispatches the task to the pool once it's given a slot.
+func (s *classScheduler) submit(class string, task func()) error {
+	s.lock.Lock()
+	// Check it within the lock scope, so that a waiter can't be queued after the waiters are rejected by reject().
+	if s.pool.IsClosed() {
+		s.lock.Unlock()
+		return ErrPoolClosed
+	}
+	if s.queued == 0 && s.hasSlot() {
+		s.inflight++
+		s.lock.Unlock()
+		return s.dispatch(task)
+	}
+	// Like Pool.Submit, don't wait for a slot under Nonblocking or beyond MaxBlockingTasks.
+	if opts := s.pool.options; opts.Nonblocking || (opts.MaxBlockingTasks != 0 && s.pool.Waiting() >= opts.MaxBlockingTasks) {
+		s.lock.Unlock()
+		atomic.AddUint64(&s.pool.rejected, 1)
+		return ErrPoolOverload
+	}
+	c := s.class(class)
+	waiter := &classWaiter{ready: make(chan struct{}), task: task}
+	if s.pool.options.AgingThreshold > 0 {
+		waiter.since = time.Now()
+	}
+	c.waiters.PushBack(waiter)
+	s.queued++
+	s.pool.addWaiting(1)
+	s.lock.Unlock()
+
+	<-waiter.ready
+	if waiter.rejected {
+		return ErrPoolClosed
+	}
+	return s.dispatch(task)
+}
+
+func (s *classScheduler) dispatch(task func()) error {
+	// Pool.ShutdownNow() hands back the task rather than the wrapper, which mustn't give back the slot twice.
+	_, _, err := s.pool.submitAs(context.Background(), func() {
+		defer s.done()
+		task()
+	}, task, "")
+	if err != nil {
+		s.done()
+	}
+	return err
+}
+
+// done gives back the slot of a class task, it's handed over to the waiters as long as there is a free slot.
+func (s *classScheduler) done() {
+	s.lock.Lock()
+	s.inflight--
+	for s.queued > 0 && s.hasSlot() {
+		c := s.next()
+		waiter := c.waiters.Remove(c.waiters.Front()).(*classWaiter)
+		if c.waiters.Len() == 0 {
+			// Don't let an idle class carry its credit or debt over to the next saturation.
+			c.current = 0
+		}
+		s.queued--
+		s.inflight++
+		s.pool.addWaiting(-1)
+		close(waiter.ready)
+	}
+	s.lock.Unlock()
+}
+
+// reject wakes all the waiters up with ErrPoolClosed once the pool is closed, and returns their tasks.
+func (s *classScheduler) reject() (tasks []func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, c := range s.classes {
+		for e := c.waiters.Front(); e != nil; e = e.Next() {
+			waiter := e.Value.(*classWaiter)
+			tasks = append(tasks, waiter.task)
+			waiter.rejected = true
+			close(waiter.ready)
+		}
+		c.waiters.Init()
+		c.current = 0
+	}
+	s.pool.addWaiting(-s.queued)
+	s.queued = 0
+	return
+}
+
+// waiting returns the number of the submitters of the class waiting for a slot.
+func (s *classScheduler) waiting(class string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if c, ok := s.classes[class]; ok {
+		return c.waiters.Len()
+	}
+	return 0
+}
+
+func (s *classScheduler) hasSlot() bool {
+	capacity := s.pool.Cap()
+	return capacity == -1 || s.inflight < capacity
+}
+
+// next picks the class to be served next among the classes with waiters by the smooth weighted round-robin.
+func (s *classScheduler) next() (picked *taskClass) {
+	if aged := s.aged(); aged != nil {
+		return aged
+	}
+	total := 0
+	for _, c := range s.classes {
+		if c.waiters.Len() == 0 {
+			continue
+		}
+		c.current += c.weight
+		total += c.weight
+		if picked == nil || c.current > picked.current {
+			picked = c
+		}
+	}
+	picked.current -= total
+	return
+}
+
+// aged returns the class of the oldest waiter blocked beyond AgingThreshold, if any.
+func (s *classScheduler) aged() (picked *taskClass) {
+	threshold := s.pool.options.AgingThreshold
+	if threshold <= 0 {
+		return nil
+	}
+	oldest := time.Now().Add(-threshold)
+	for _, c := range s.classes {
+		if front := c.waiters.Front(); front != nil {
+			if since := front.Value.(*classWaiter).since; since.Before(oldest) {
+				picked, oldest = c, since
+			}
+		}
+	}
+	return
+}
+
+func (s *classScheduler) class(name string) *taskClass {
+	c, ok := s.classes[name]
+	if !ok {
+		weight := s.pool.options.ClassWeights[name]
+		if weight <= 0 {
+			weight = 1
+		}
+		c = &taskClass{weight: weight, waiters: list.New()}
+		s.classes[name] = c
+	}
+	return c
+}
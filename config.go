@@ -0,0 +1,127 @@
+package ants
+
+import "time"
+
+// Config is the serializable part of the configuration of a Pool, e.g. with encoding/json, which is
+// returned by Pool.Config and reconstructed by NewPoolFromConfig. The options holding functions or
+// interfaces, such as PanicHandler and Logger, can't be serialized and aren't part of it.
+type Config struct {
+	Capacity                int
+	ExpiryDuration          time.Duration
+	PreAlloc                bool
+	MaxBlockingTasks        int
+	Nonblocking             bool
+	DisablePurge            bool
+	MemoryBudget            uint64
+	LatencyTracking         bool
+	NoReuse                 bool
+	CapacityPerCPU          int
+	PanicQuarantine         int
+	InitialWorkerCap        int
+	SaturationAlertDuration time.Duration
+	StuckTaskDump           time.Duration
+	MaxIdleWorkers          int
+	CaptureCaller           bool
+	CPUBound                int
+	StackWarm               int
+	EventsBuffer            int
+	ClassWeights            map[string]int
+	AgingThreshold          time.Duration
+	FIFOTasks               bool
+	AcceptDuringDrain       bool
+	MaxQueueLatency         time.Duration
+	Synchronous             bool
+	Validate                bool
+	SpawnRate               float64
+	ResetCountersOnReboot   bool
+	MemoryPressureLimit     uint64
+	ExecTimeTracking        bool
+}
+
+// Config returns the current configuration of this pool, including the changes made by Tune and Reconfigure.
+func (p *Pool) Config() Config {
+	opts := p.currentOptions()
+	return Config{
+		Capacity:                p.Cap(),
+		ExpiryDuration:          opts.ExpiryDuration,
+		PreAlloc:                opts.PreAlloc,
+		MaxBlockingTasks:        opts.MaxBlockingTasks,
+		Nonblocking:             opts.Nonblocking,
+		DisablePurge:            opts.DisablePurge,
+		MemoryBudget:            opts.MemoryBudget,
+		LatencyTracking:         opts.LatencyTracking,
+		NoReuse:                 opts.NoReuse,
+		CapacityPerCPU:          opts.CapacityPerCPU,
+		PanicQuarantine:         opts.PanicQuarantine,
+		InitialWorkerCap:        opts.InitialWorkerCap,
+		SaturationAlertDuration: opts.SaturationAlertDuration,
+		StuckTaskDump:           opts.StuckTaskDump,
+		MaxIdleWorkers:          opts.MaxIdleWorkers,
+		CaptureCaller:           opts.CaptureCaller,
+		CPUBound:                opts.CPUBound,
+		StackWarm:               opts.StackWarm,
+		EventsBuffer:            opts.EventsBuffer,
+		ClassWeights:            copyWeights(opts.ClassWeights),
+		AgingThreshold:          opts.AgingThreshold,
+		FIFOTasks:               opts.FIFOTasks,
+		AcceptDuringDrain:       opts.AcceptDuringDrain,
+		MaxQueueLatency:         opts.MaxQueueLatency,
+		Synchronous:             opts.Synchronous,
+		Validate:                opts.Validate,
+		SpawnRate:               opts.SpawnRate,
+		ResetCountersOnReboot:   opts.ResetCountersOnReboot,
+		MemoryPressureLimit:     opts.MemoryPressureLimit,
+		ExecTimeTracking:        opts.ExecTimeTracking,
+	}
+}
+
+// NewPoolFromConfig generates an instance of ants pool configured by cfg, the options are applied on top of it,
+// which can set up the options that are not part of Config.
+func NewPoolFromConfig(cfg Config, options ...Option) (*Pool, error) {
+	return NewPool(cfg.Capacity, append([]Option{withConfig(cfg)}, options...)...)
+}
+
+func withConfig(cfg Config) Option {
+	return func(opts *Options) {
+		opts.ExpiryDuration = cfg.ExpiryDuration
+		opts.PreAlloc = cfg.PreAlloc
+		opts.MaxBlockingTasks = cfg.MaxBlockingTasks
+		opts.Nonblocking = cfg.Nonblocking
+		opts.DisablePurge = cfg.DisablePurge
+		opts.MemoryBudget = cfg.MemoryBudget
+		opts.LatencyTracking = cfg.LatencyTracking
+		opts.NoReuse = cfg.NoReuse
+		opts.CapacityPerCPU = cfg.CapacityPerCPU
+		opts.PanicQuarantine = cfg.PanicQuarantine
+		opts.InitialWorkerCap = cfg.InitialWorkerCap
+		opts.SaturationAlertDuration = cfg.SaturationAlertDuration
+		opts.StuckTaskDump = cfg.StuckTaskDump
+		opts.MaxIdleWorkers = cfg.MaxIdleWorkers
+		opts.CaptureCaller = cfg.CaptureCaller
+		opts.CPUBound = cfg.CPUBound
+		opts.StackWarm = cfg.StackWarm
+		opts.EventsBuffer = cfg.EventsBuffer
+		opts.ClassWeights = copyWeights(cfg.ClassWeights)
+		opts.AgingThreshold = cfg.AgingThreshold
+		opts.FIFOTasks = cfg.FIFOTasks
+		opts.AcceptDuringDrain = cfg.AcceptDuringDrain
+		opts.MaxQueueLatency = cfg.MaxQueueLatency
+		opts.Synchronous = cfg.Synchronous
+		opts.Validate = cfg.Validate
+		opts.SpawnRate = cfg.SpawnRate
+		opts.ResetCountersOnReboot = cfg.ResetCountersOnReboot
+		opts.MemoryPressureLimit = cfg.MemoryPressureLimit
+		opts.ExecTimeTracking = cfg.ExecTimeTracking
+	}
+}
+
+func copyWeights(weights map[string]int) map[string]int {
+	if weights == nil {
+		return nil
+	}
+	copied := make(map[string]int, len(weights))
+	for class, weight := range weights {
+		copied[class] = weight
+	}
+	return copied
+}
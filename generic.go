@@ -0,0 +1,175 @@
+//go:build go1.18
+// +build go1.18
+
+package ants
+
+import "sync"
+
+// Bind returns a task that calls fn with arg, which can be submitted to Pool.Submit().
+func Bind[A any](fn func(A), arg A) func() {
+	return func() {
+		fn(arg)
+	}
+}
+
+// Bind2 is like Bind but for the functions with two arguments.
+func Bind2[A, B any](fn func(A, B), a A, b B) func() {
+	return func() {
+		fn(a, b)
+	}
+}
+
+// Bind3 is like Bind but for the functions with three arguments.
+func Bind3[A, B, C any](fn func(A, B, C), a A, b B, c C) func() {
+	return func() {
+		fn(a, b, c)
+	}
+}
+
+// Map runs fn over items on the pool and returns the results in the order of items.
+// An item the pool rejects is processed on the calling goroutine.
+func Map[T, R any](pool *Pool, items []T, fn func(T) R) []R {
+	results := make([]R, len(items))
+	var wg sync.WaitGroup
+	for i := range items {
+		i := i
+		wg.Add(1)
+		task := func() {
+			defer wg.Done()
+			results[i] = fn(items[i])
+		}
+		submitOrRun(pool, task)
+	}
+	wg.Wait()
+	return results
+}
+
+// Scatter is like Map, but fn is also passed the index of the item and returns an error.
+// The errors are returned in the order of items as well.
+func Scatter[T, R any](pool *Pool, items []T, fn func(int, T) (R, error)) ([]R, []error) {
+	results := make([]R, len(items))
+	errs := make([]error, len(items))
+	var wg sync.WaitGroup
+	for i := range items {
+		i := i
+		wg.Add(1)
+		task := func() {
+			defer wg.Done()
+			results[i], errs[i] = fn(i, items[i])
+		}
+		submitOrRun(pool, task)
+	}
+	wg.Wait()
+	return results, errs
+}
+
+// SubmitE runs task on the pool and waits for its result.
+// A panic of the task is returned as a *PanicError.
+func SubmitE[T any](pool *Pool, task func() (T, error)) (result T, err error) {
+	err = pool.SubmitSafe(func() (err error) {
+		result, err = task()
+		return
+	})
+	return
+}
+
+// Stream runs fn over the inputs from in on the pool and sends the results in the order they complete.
+// The returned channel is closed once in is closed and drained.
+// An input the pool rejects is processed on the goroutine reading in.
+func Stream[T, R any](pool *Pool, in <-chan T, fn func(T) R) <-chan R {
+	out := make(chan R)
+	go func() {
+		var wg sync.WaitGroup
+		for item := range in {
+			item := item
+			wg.Add(1)
+			task := func() {
+				defer wg.Done()
+				out <- fn(item)
+			}
+			submitOrRun(pool, task)
+		}
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// ConsumeN runs fn over the elements from in on the pool until in is closed and drained.
+// An element the pool rejects is processed on the calling goroutine.
+func ConsumeN[T any](pool *Pool, in <-chan T, fn func(T)) {
+	var wg sync.WaitGroup
+	for item := range in {
+		item := item
+		wg.Add(1)
+		task := func() {
+			defer wg.Done()
+			fn(item)
+		}
+		submitOrRun(pool, task)
+	}
+	wg.Wait()
+}
+
+// submitOrRun submits task to the pool, or runs it on the calling goroutine if the pool rejects it.
+func submitOrRun(pool *Pool, task func()) {
+	if err := pool.Submit(task); err != nil {
+		task()
+	}
+}
+
+// TypedPool is a pool of the tasks returning results of type T.
+// The results can be read per task from Submit, or all at once by Collect.
+type TypedPool[T any] struct {
+	pool *Pool
+
+	wg      sync.WaitGroup
+	lock    sync.Mutex
+	results []T
+}
+
+// NewTypedPool generates an instance of TypedPool whose concurrency is bounded by size.
+func NewTypedPool[T any](size int, options ...Option) (*TypedPool[T], error) {
+	pool, err := NewPool(size, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedPool[T]{pool: pool}, nil
+}
+
+// Submit submits a task to this pool and returns the channel receiving its result.
+// The channel is closed without any result if the task panics.
+func (p *TypedPool[T]) Submit(task func() T) (<-chan T, error) {
+	ch := make(chan T, 1)
+	p.wg.Add(1)
+	err := p.pool.Submit(func() {
+		defer p.wg.Done()
+		defer close(ch)
+		result := task()
+		p.lock.Lock()
+		p.results = append(p.results, result)
+		p.lock.Unlock()
+		ch <- result
+	})
+	if err != nil {
+		p.wg.Done()
+		return nil, err
+	}
+	return ch, nil
+}
+
+// Collect waits for the submitted tasks and returns their results in the order they completed.
+// Each result is only returned once.
+func (p *TypedPool[T]) Collect() []T {
+	p.wg.Wait()
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	results := p.results
+	p.results = nil
+	return results
+}
+
+// Release closes this pool and releases the worker queue.
+func (p *TypedPool[T]) Release() {
+	p.pool.Release()
+}
@@ -0,0 +1,199 @@
+//go:build go1.18
+// +build go1.18
+
+package ants
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBind(t *testing.T) {
+	p, _ := NewPool(10)
+	defer p.Release()
+
+	var sum int64
+	var wg sync.WaitGroup
+	add := func(n int64) {
+		atomic.AddInt64(&sum, n)
+		wg.Done()
+	}
+	add2 := func(a, b int64) {
+		add(a + b)
+	}
+	add3 := func(a, b, c int64) {
+		add(a + b + c)
+	}
+
+	wg.Add(3)
+	assert.NoError(t, p.Submit(Bind(add, 1)))
+	assert.NoError(t, p.Submit(Bind2(add2, 2, 3)))
+	assert.NoError(t, p.Submit(Bind3(add3, 4, 5, 6)))
+	wg.Wait()
+	assert.EqualValues(t, 21, atomic.LoadInt64(&sum))
+}
+
+func TestMap(t *testing.T) {
+	p, _ := NewPool(4)
+	defer p.Release()
+
+	items := make([]int, 100)
+	for i := range items {
+		items[i] = i
+	}
+	squares := Map(p, items, func(n int) int {
+		// Make the tasks complete out of order.
+		time.Sleep(time.Duration(len(items)-n) * 10 * time.Microsecond)
+		return n * n
+	})
+	assert.Len(t, squares, len(items))
+	for i, sq := range squares {
+		assert.EqualValuesf(t, i*i, sq, "result %d is misaligned", i)
+	}
+
+	p.Release()
+	assert.Equal(t, []int{1, 4}, Map(p, []int{1, 2}, func(n int) int { return n * n }),
+		"items should be processed even if the pool is closed")
+}
+
+func TestScatter(t *testing.T) {
+	p, _ := NewPool(4)
+	defer p.Release()
+
+	items := make([]int, 100)
+	for i := range items {
+		items[i] = i * 10
+	}
+	errOdd := errors.New("odd index")
+	var maxRunning int32
+	results, errs := Scatter(p, items, func(i, n int) (int, error) {
+		if running := int32(p.Running()); running > atomic.LoadInt32(&maxRunning) {
+			atomic.StoreInt32(&maxRunning, running)
+		}
+		// Make the tasks complete out of order.
+		time.Sleep(time.Duration(len(items)-i) * 10 * time.Microsecond)
+		if i%2 == 1 {
+			return 0, errOdd
+		}
+		return n + i, nil
+	})
+	assert.Len(t, results, len(items))
+	assert.Len(t, errs, len(items))
+	for i := range items {
+		if i%2 == 1 {
+			assert.Equalf(t, errOdd, errs[i], "error %d is misaligned", i)
+			assert.Zero(t, results[i])
+			continue
+		}
+		assert.NoErrorf(t, errs[i], "error %d is misaligned", i)
+		assert.EqualValuesf(t, i*11, results[i], "result %d is misaligned", i)
+	}
+	assert.LessOrEqual(t, atomic.LoadInt32(&maxRunning), int32(4), "the concurrency should be bounded by the pool")
+}
+
+func TestSubmitE(t *testing.T) {
+	p, _ := NewPool(2)
+	defer p.Release()
+
+	n, err := SubmitE(p, func() (int, error) { return 42, nil })
+	assert.NoError(t, err)
+	assert.EqualValues(t, 42, n)
+
+	errBoom := errors.New("boom")
+	n, err = SubmitE(p, func() (int, error) { return 0, errBoom })
+	assert.Equal(t, errBoom, err, "the error of the task should be returned")
+	assert.Zero(t, n)
+
+	n, err = SubmitE(p, func() (int, error) { panic("oops") })
+	var pe *PanicError
+	assert.True(t, errors.As(err, &pe), "the panic should be recovered into a *PanicError")
+	assert.EqualValues(t, "oops", pe.Value)
+	assert.Zero(t, n)
+
+	p.Release()
+	_, err = SubmitE(p, func() (int, error) { return 1, nil })
+	assert.EqualError(t, err, ErrPoolClosed.Error())
+}
+
+func TestStream(t *testing.T) {
+	p, _ := NewPool(4)
+	defer p.Release()
+
+	const n = 100
+	in := make(chan int)
+	go func() {
+		for i := 0; i < n; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+	seen := make(map[int]bool, n)
+	for sq := range Stream(p, in, func(i int) int { return i * i }) {
+		seen[sq] = true
+	}
+	assert.Len(t, seen, n, "every input should have a result")
+	for i := 0; i < n; i++ {
+		assert.Truef(t, seen[i*i], "the result of %d is missing", i)
+	}
+}
+
+func TestConsumeN(t *testing.T) {
+	p, _ := NewPool(4)
+	defer p.Release()
+
+	const n = 100
+	in := make(chan int)
+	go func() {
+		for i := 0; i < n; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+	var running, peak, sum int32
+	ConsumeN(p, in, func(i int) {
+		if r := atomic.AddInt32(&running, 1); r > atomic.LoadInt32(&peak) {
+			atomic.StoreInt32(&peak, r)
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&sum, int32(i))
+		atomic.AddInt32(&running, -1)
+	})
+	assert.EqualValues(t, n*(n-1)/2, atomic.LoadInt32(&sum), "every element should be processed before returning")
+	assert.LessOrEqual(t, atomic.LoadInt32(&peak), int32(4), "the concurrency should be bounded by the pool")
+}
+
+func TestTypedPool(t *testing.T) {
+	p, err := NewTypedPool[int](4)
+	assert.NoError(t, err)
+	defer p.Release()
+
+	const n = 20
+	chs := make([]<-chan int, n)
+	expected := make([]int, n)
+	for i := 0; i < n; i++ {
+		i := i
+		expected[i] = i * i
+		chs[i], err = p.Submit(func() int {
+			// Complete in the reverse order of the submissions.
+			time.Sleep(time.Duration(n-i) * time.Millisecond)
+			return i * i
+		})
+		assert.NoError(t, err)
+	}
+	for i, ch := range chs {
+		assert.Equal(t, i*i, <-ch, "the channel should receive the result of its task")
+	}
+	results := p.Collect()
+	assert.Len(t, results, n, "every task should have a result")
+	assert.ElementsMatch(t, expected, results)
+	assert.Empty(t, p.Collect(), "the results should only be collected once")
+
+	p.Release()
+	_, err = p.Submit(func() int { return 0 })
+	assert.EqualError(t, err, ErrPoolClosed.Error())
+}
@@ -0,0 +1,53 @@
+package ants
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the inclusive upper bounds of the buckets in a latency histogram,
+// the last one collects all the latencies beyond the others.
+var latencyBuckets = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	math.MaxInt64,
+}
+
+// latencyHistogram counts the execution time of tasks into fixed buckets.
+type latencyHistogram struct {
+	counts [len(latencyBuckets)]uint64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	for i, bound := range latencyBuckets {
+		if d <= bound {
+			atomic.AddUint64(&h.counts[i], 1)
+			return
+		}
+	}
+}
+
+func (h *latencyHistogram) reset() {
+	for i := range h.counts {
+		atomic.StoreUint64(&h.counts[i], 0)
+	}
+}
+
+func (h *latencyHistogram) snapshot() map[time.Duration]uint64 {
+	m := make(map[time.Duration]uint64, len(latencyBuckets))
+	for i, bound := range latencyBuckets {
+		m[bound] = atomic.LoadUint64(&h.counts[i])
+	}
+	return m
+}
@@ -0,0 +1,114 @@
+package ants
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PoolManager runs the background jobs, purging the stale workers and updating the current time,
+// of all the pools it has created in a single goroutine, instead of two goroutines per pool,
+// which saves the overhead of having many small pools.
+type PoolManager struct {
+	lock  sync.Mutex
+	pools map[*Pool]*managedPool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// managedPool is the state of the background jobs of a registered pool, it's only accessed by the manager goroutine.
+type managedPool struct {
+	lastPurge time.Time
+	alert     saturationAlert
+}
+
+// NewPoolManager creates a PoolManager and starts its goroutine.
+func NewPoolManager() *PoolManager {
+	m := &PoolManager{
+		pools: make(map[*Pool]*managedPool),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+// NewPool is like ants.NewPool, but the pool is managed by this manager, so that it starts
+// no background goroutine. The pool is registered until it's released, and registered again on Reboot.
+// Note that the stale workers are purged at the granularity of 500ms, so a shorter ExpiryDuration is rounded up.
+func (m *PoolManager) NewPool(size int, options ...Option) (*Pool, error) {
+	return newPool(size, m, options...)
+}
+
+// Len returns the number of the pools currently registered with this manager.
+func (m *PoolManager) Len() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.pools)
+}
+
+// Close stops the goroutine of this manager, it should be called after all of its pools are released
+// since the pools still registered are no longer purged.
+func (m *PoolManager) Close() {
+	select {
+	case <-m.stop:
+	default:
+		close(m.stop)
+	}
+	<-m.done
+}
+
+func (m *PoolManager) register(p *Pool) {
+	m.lock.Lock()
+	m.pools[p] = &managedPool{lastPurge: time.Now()}
+	m.lock.Unlock()
+}
+
+func (m *PoolManager) deregister(p *Pool) {
+	m.lock.Lock()
+	delete(m.pools, p)
+	m.lock.Unlock()
+}
+
+func (m *PoolManager) run() {
+	ticker := time.NewTicker(nowTimeUpdateInterval)
+	defer func() {
+		ticker.Stop()
+		close(m.done)
+	}()
+
+	var (
+		pools  []*Pool
+		states []*managedPool
+	)
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+
+		pools, states = pools[:0], states[:0]
+		m.lock.Lock()
+		for p, state := range m.pools {
+			pools = append(pools, p)
+			states = append(states, state)
+		}
+		m.lock.Unlock()
+
+		now := time.Now()
+		for i, p := range pools {
+			if p.IsClosed() {
+				continue
+			}
+			p.tick(now, &states[i].alert)
+			if !p.options.DisablePurge && atomic.LoadInt32(&p.purgeDone) == 0 &&
+				now.Sub(states[i].lastPurge) >= p.hotOptions().expiry {
+				states[i].lastPurge = now
+				p.purge()
+			}
+			pools[i], states[i] = nil, nil
+		}
+	}
+}
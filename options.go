@@ -42,6 +42,175 @@ type Options struct {
 
 	// When DisablePurge is true, workers are not purged and are resident.
 	DisablePurge bool
+
+	// MemoryBudget is the maximum estimated bytes of all in-flight tasks,
+	// it is only in effect when MemoryEstimator is set.
+	MemoryBudget uint64
+
+	// MemoryEstimator estimates how many bytes a task will hold while it is in flight,
+	// Pool.Submit returns ErrMemoryBudgetExceeded when the task doesn't fit in MemoryBudget.
+	MemoryEstimator func(task func()) uint64
+
+	// When LatencyTracking is true, the execution time of each task is counted into a histogram
+	// reported by Pool.LatencyHistogram(), and summed up into Pool.TotalExecTime() as well.
+	LatencyTracking bool
+
+	// When ExecTimeTracking is true, the execution time of the tasks is summed up into Pool.TotalExecTime()
+	// without the histogram. The clock isn't read around the tasks unless either of them is set.
+	ExecTimeTracking bool
+
+	// When NoReuse is true, each worker exits after running one task, so that every task runs on
+	// a fresh goroutine, which helps to debug the leaks of goroutine-local state at the cost of performance.
+	NoReuse bool
+
+	// CapacityPerCPU sizes a pool created with a non-positive size to CapacityPerCPU * runtime.GOMAXPROCS(0).
+	CapacityPerCPU int
+
+	// PanicQuarantine is the number of panics a worker can recover from before it gets quarantined.
+	// When it's positive, a worker survives the panics from its tasks, and it's stopped and replaced
+	// by a fresh worker once it has recovered from PanicQuarantine panics, since its state might be tainted.
+	// 0 (default value) means that a worker exits on the first panic.
+	PanicQuarantine int
+
+	// InitialWorkerCap pre-sizes the worker queue of a pool without PreAlloc, which avoids
+	// the repeated reallocations of the queue while a large pool ramps up.
+	InitialWorkerCap int
+
+	// SaturationAlert is called once the pool has stayed saturated, with no available worker and
+	// some goroutines blocked on submitting, for longer than SaturationAlertDuration.
+	// It's called at most once per saturation episode.
+	SaturationAlert func()
+
+	// SaturationAlertDuration is how long the pool must stay saturated before SaturationAlert is called.
+	SaturationAlertDuration time.Duration
+
+	// Middlewares wrap every task submitted to Pool before it's executed on a worker,
+	// the first one is the outermost.
+	Middlewares []func(next func()) func()
+
+	// ContextExtractor captures the goroutine-local context, e.g. a trace span, of the goroutine
+	// submitting a task to Pool, and ContextInjector restores it on the worker before running the task,
+	// ContextInjector is called with nil to clear it after the task completes.
+	ContextExtractor func() interface{}
+	ContextInjector  func(interface{})
+
+	// StuckTaskDump is the threshold beyond which a running task is regarded as stuck, and the stack
+	// of its worker is dumped through Logger once, the check is done every 500ms.
+	// 0 (default value) means no such dump.
+	StuckTaskDump time.Duration
+
+	// DeadLetter receives the tasks submitted to Pool which panicked, along with the panic values,
+	// for persistence or alerting. The panics of those tasks are recovered, so PanicHandler isn't called for them.
+	DeadLetter func(task func(), panicVal interface{})
+
+	// MaxIdleWorkers is the maximum number of idle workers kept in the pool, the workers finishing
+	// their tasks beyond it are stopped at once instead of waiting to expire, which bounds the memory
+	// of idle goroutines after a spike. 0 (default value) means no such limit.
+	MaxIdleWorkers int
+
+	// When CaptureCaller is true, Pool records the caller submitting each task, so that the callers
+	// of the running tasks can be inspected by Pool.InflightCallers() to attribute the hanging tasks.
+	CaptureCaller bool
+
+	// Admitter admits every task submitted to Pool before it's dispatched to a worker, and it's released
+	// once the task completes, on top of the capacity of the pool. The submissions which never block, such as
+	// Pool.SubmitIfAvailable(), only admit a task at once, see TryAdmitter. nil (default value) means that
+	// only the capacity governs the admission.
+	Admitter Admitter
+
+	// CPUBound is the maximum number of tasks of Pool running their CPU-bound sections, which are
+	// wrapped in Pool.RunCPUBound(), at the same time, while the capacity of the pool governs the I/O concurrency.
+	// 0 (default value) means no such limit.
+	CPUBound int
+
+	// StackWarm is the depth of a no-op recursion run by every worker as it starts, which grows the
+	// goroutine stack ahead of time so that the first deep-call task doesn't pay for the stack growth.
+	// 0 (default value) means no such warm-up.
+	StackWarm int
+
+	// EventsBuffer is the buffer size of the channel returned by Pool.Events(), the events are dropped
+	// instead of blocking the pool when the buffer is full. 0 (default value) means that no event is emitted.
+	EventsBuffer int
+
+	// ClassWeights are the weights of the task classes submitted by Pool.SubmitClass, the workers of
+	// a saturated pool are distributed across the classes in proportion to their weights.
+	// A class without a positive weight has the weight of 1.
+	ClassWeights map[string]int
+
+	// AgingThreshold is how long a submitter of Pool.SubmitClass can be blocked before it's served next
+	// regardless of the weights, which prevents the classes with low weights from starving under
+	// the continuous load of the classes with high weights. 0 (default value) means no such aging.
+	AgingThreshold time.Duration
+
+	// FIFOTasks makes the tasks blocked on a saturated Pool get the workers in the order they were submitted,
+	// rather than in the order their submitters happen to wake up, and the new tasks queue up behind them.
+	// The order is best-effort, the tasks moved by DrainTo or woken by Release don't take part in it.
+	FIFOTasks bool
+
+	// AcceptDuringDrain lets Pool.Submit() accept new tasks while Pool.ReleaseDrainQueue() is draining the pool,
+	// as long as a worker is available at once, otherwise the task is rejected with ErrPoolClosed without blocking,
+	// and the drain waits for the running tasks too. By default, all submissions are rejected with ErrPoolClosed
+	// once the drain begins.
+	AcceptDuringDrain bool
+
+	// OnFull is called once whenever the Pool becomes saturated, that is, all workers up to the capacity
+	// have been spawned and are busy, it's not called again until the pool drops below full capacity.
+	// It's called on the goroutine that saturates the pool, so it should return quickly.
+	OnFull func()
+
+	// DynamicCapacity computes the capacity of the Pool, e.g. from a live config value, it's called when
+	// the pool is created and then at dispatch time at most once per 100ms, and the pool is tuned to
+	// the returned value as Pool.Tune() does, so that an external controller can drive the sizing.
+	// A non-positive value leaves the capacity unchanged.
+	DynamicCapacity func() int
+
+	// MaxQueueLatency is the longest a task submitted by Pool.Submit() is expected to wait for a worker,
+	// every task waiting longer counts as a breach reported by Pool.SLABreaches() and is passed to
+	// OnQueueLatencyBreach if it's set. 0 (default value) means no such target.
+	MaxQueueLatency time.Duration
+
+	// OnQueueLatencyBreach is called with how long the task waited whenever MaxQueueLatency is breached,
+	// on the goroutine that submitted the task.
+	OnQueueLatencyBreach func(waited time.Duration)
+
+	// SpawnRate is the most workers spawned per second by Pool, the submitters needing a new worker
+	// wait for their turns instead of spawning a burst of workers at once into a cold pool, while the
+	// submissions which never block, like Pool.SubmitIfAvailable() or any submission under Nonblocking,
+	// don't get a new worker until a turn is due, Nonblocking submissions get ErrPoolOverload instead.
+	// 0 (default value) means no limit.
+	SpawnRate float64
+
+	// ResetCountersOnReboot indicates whether Pool.Reboot() resets the lifetime counters of the pool, such as
+	// Pool.TotalSubmitted(), Pool.TotalCompleted(), Pool.PeakRunning(), Pool.TotalRejected(), Pool.SLABreaches(),
+	// Pool.TotalExecTime() and Pool.LatencyHistogram(),
+	// by default they're kept across the reboots.
+	ResetCountersOnReboot bool
+
+	// CircuitBreaker is consulted by every submission to Pool, which is rejected with ErrCircuitOpen while
+	// the breaker is open, and it's fed with the outcomes of the tasks submitted by Pool.SubmitSafe(),
+	// Pool.SubmitCallbackErr() and Pool.SubmitBackoff(). nil (default value) means no circuit breaker.
+	CircuitBreaker CircuitBreaker
+
+	// AutoReleaseAt is when Pool releases itself like Pool.Release(), letting the running tasks finish,
+	// which bounds the wall-clock time of an ephemeral batch job. The timer is stopped if the pool is released
+	// earlier, and a rebooted pool is no longer released at that time. The zero value means no such release.
+	AutoReleaseAt time.Time
+
+	// MemoryPressureLimit is the high-water threshold of the heap usage of the process in bytes, beyond which
+	// the submissions to Pool are rejected with a *PoolMemoryPressureError to keep the process away from OOM.
+	// The heap usage is sampled by runtime.ReadMemStats() every 500ms. 0 (default value) means no such limit.
+	MemoryPressureLimit uint64
+
+	// When Synchronous is true, Pool.Submit and PoolWithFunc.Invoke run the task inline on the calling goroutine
+	// instead of dispatching it to a worker, which makes the unit tests of the code using the pool deterministic.
+	// The task is counted by Running() while it runs, and its panic is handled by PanicHandler like on a worker.
+	// Pool.NewSession() fails with ErrSynchronousSession since there is no worker to hold.
+	Synchronous bool
+
+	// When Validate is true, NewPool spawns a worker and runs a no-op probe on it, and returns the error
+	// if it fails, which surfaces the setup problems at construction rather than at the first submission.
+	// The probe bypasses the Admitter, the middlewares and the events, and isn't counted as a task.
+	Validate bool
 }
 
 // WithOptions accepts the whole options config.
@@ -99,3 +268,231 @@ func WithDisablePurge(disable bool) Option {
 		opts.DisablePurge = disable
 	}
 }
+
+// WithMemoryGuard sets up a memory budget for in-flight tasks, which only takes effect on Pool.
+func WithMemoryGuard(maxBytes uint64, estimator func(task func()) uint64) Option {
+	return func(opts *Options) {
+		opts.MemoryBudget = maxBytes
+		opts.MemoryEstimator = estimator
+	}
+}
+
+// WithLatencyTracking indicates whether it should record the execution time of tasks into a histogram.
+func WithLatencyTracking(latencyTracking bool) Option {
+	return func(opts *Options) {
+		opts.LatencyTracking = latencyTracking
+	}
+}
+
+// WithExecTimeTracking indicates whether it should sum up the execution time of tasks.
+func WithExecTimeTracking(execTimeTracking bool) Option {
+	return func(opts *Options) {
+		opts.ExecTimeTracking = execTimeTracking
+	}
+}
+
+// WithNoReuse indicates whether it should run every task on a fresh goroutine.
+func WithNoReuse(noReuse bool) Option {
+	return func(opts *Options) {
+		opts.NoReuse = noReuse
+	}
+}
+
+// WithCapacityPerCPU sets up the capacity of pool as a multiple of GOMAXPROCS.
+func WithCapacityPerCPU(multiplier int) Option {
+	return func(opts *Options) {
+		opts.CapacityPerCPU = multiplier
+	}
+}
+
+// WithPanicQuarantine sets up the number of panics after which a worker is quarantined.
+func WithPanicQuarantine(threshold int) Option {
+	return func(opts *Options) {
+		opts.PanicQuarantine = threshold
+	}
+}
+
+// WithInitialWorkerCap sets up the initial capacity of the worker queue.
+func WithInitialWorkerCap(n int) Option {
+	return func(opts *Options) {
+		opts.InitialWorkerCap = n
+	}
+}
+
+// WithSaturationAlert sets up a callback for the pool staying saturated for longer than duration.
+func WithSaturationAlert(duration time.Duration, cb func()) Option {
+	return func(opts *Options) {
+		opts.SaturationAlertDuration = duration
+		opts.SaturationAlert = cb
+	}
+}
+
+// WithMiddleware appends middlewares that wrap every task submitted to Pool, they compose in order.
+func WithMiddleware(middlewares ...func(next func()) func()) Option {
+	return func(opts *Options) {
+		opts.Middlewares = append(opts.Middlewares, middlewares...)
+	}
+}
+
+// WithContextPropagation sets up the propagation of goroutine-local context from the submitters to the workers of Pool.
+func WithContextPropagation(extract func() interface{}, inject func(interface{})) Option {
+	return func(opts *Options) {
+		opts.ContextExtractor = extract
+		opts.ContextInjector = inject
+	}
+}
+
+// WithStuckTaskDump sets up the threshold for dumping the stacks of stuck tasks.
+func WithStuckTaskDump(threshold time.Duration) Option {
+	return func(opts *Options) {
+		opts.StuckTaskDump = threshold
+	}
+}
+
+// WithDeadLetter sets up a sink for the tasks which panicked.
+func WithDeadLetter(sink func(task func(), panicVal interface{})) Option {
+	return func(opts *Options) {
+		opts.DeadLetter = sink
+	}
+}
+
+// WithMaxIdleWorkers sets up the maximum number of idle workers.
+func WithMaxIdleWorkers(n int) Option {
+	return func(opts *Options) {
+		opts.MaxIdleWorkers = n
+	}
+}
+
+// WithCaptureCaller indicates whether it should record the caller submitting each task.
+func WithCaptureCaller(captureCaller bool) Option {
+	return func(opts *Options) {
+		opts.CaptureCaller = captureCaller
+	}
+}
+
+// WithAdmitter sets up an admission controller.
+func WithAdmitter(admitter Admitter) Option {
+	return func(opts *Options) {
+		opts.Admitter = admitter
+	}
+}
+
+// WithCPUBound sets up the maximum number of tasks running their CPU-bound sections at the same time.
+func WithCPUBound(limit int) Option {
+	return func(opts *Options) {
+		opts.CPUBound = limit
+	}
+}
+
+// WithStackWarm sets up the depth of the recursion warming up the stack of every worker.
+func WithStackWarm(depth int) Option {
+	return func(opts *Options) {
+		opts.StackWarm = depth
+	}
+}
+
+// WithEvents enables the events channel of Pool with the buffer size.
+func WithEvents(buffer int) Option {
+	return func(opts *Options) {
+		opts.EventsBuffer = buffer
+	}
+}
+
+// WithClassWeights sets up the weights of the task classes.
+func WithClassWeights(weights map[string]int) Option {
+	return func(opts *Options) {
+		opts.ClassWeights = weights
+	}
+}
+
+// WithSynchronous indicates whether it should run the tasks inline on the submitting goroutines.
+func WithSynchronous(synchronous bool) Option {
+	return func(opts *Options) {
+		opts.Synchronous = synchronous
+	}
+}
+
+// WithValidate indicates whether NewPool should validate the pool by running a probe task.
+func WithValidate(validate bool) Option {
+	return func(opts *Options) {
+		opts.Validate = validate
+	}
+}
+
+// WithAgingThreshold sets up the threshold for serving the starving submitters of task classes first.
+func WithAgingThreshold(threshold time.Duration) Option {
+	return func(opts *Options) {
+		opts.AgingThreshold = threshold
+	}
+}
+
+// WithFIFOTasks indicates whether the tasks blocked on a saturated pool should get the workers in submission order.
+func WithFIFOTasks(fifo bool) Option {
+	return func(opts *Options) {
+		opts.FIFOTasks = fifo
+	}
+}
+
+// WithAcceptDuringDrain indicates whether the pool should accept new tasks with free capacity while draining its queue.
+func WithAcceptDuringDrain(accept bool) Option {
+	return func(opts *Options) {
+		opts.AcceptDuringDrain = accept
+	}
+}
+
+// WithOnFull sets up the callback invoked whenever the pool reaches its full capacity.
+func WithOnFull(onFull func()) Option {
+	return func(opts *Options) {
+		opts.OnFull = onFull
+	}
+}
+
+// WithDynamicCapacity sets up the function to compute the capacity of the pool from.
+func WithDynamicCapacity(capacity func() int) Option {
+	return func(opts *Options) {
+		opts.DynamicCapacity = capacity
+	}
+}
+
+// WithMaxQueueLatency sets up the target of the queue latency along with an optional callback for its breaches.
+func WithMaxQueueLatency(limit time.Duration, onBreach func(waited time.Duration)) Option {
+	return func(opts *Options) {
+		opts.MaxQueueLatency = limit
+		opts.OnQueueLatencyBreach = onBreach
+	}
+}
+
+// WithSpawnRate sets up the most workers spawned per second.
+func WithSpawnRate(perSecond float64) Option {
+	return func(opts *Options) {
+		opts.SpawnRate = perSecond
+	}
+}
+
+// WithResetCountersOnReboot indicates whether the pool should reset its lifetime counters on reboot.
+func WithResetCountersOnReboot(reset bool) Option {
+	return func(opts *Options) {
+		opts.ResetCountersOnReboot = reset
+	}
+}
+
+// WithCircuitBreaker sets up the circuit breaker guarding the submissions.
+func WithCircuitBreaker(cb CircuitBreaker) Option {
+	return func(opts *Options) {
+		opts.CircuitBreaker = cb
+	}
+}
+
+// WithAutoReleaseAt sets up the time the pool releases itself at.
+func WithAutoReleaseAt(t time.Time) Option {
+	return func(opts *Options) {
+		opts.AutoReleaseAt = t
+	}
+}
+
+// WithMemoryPressureLimit sets up the heap usage beyond which the submissions are rejected.
+func WithMemoryPressureLimit(bytes uint64) Option {
+	return func(opts *Options) {
+		opts.MemoryPressureLimit = bytes
+	}
+}
@@ -23,7 +23,12 @@
 package ants
 
 import (
+	"container/list"
 	"context"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -33,14 +38,49 @@ import (
 
 // Pool accepts the tasks from client, it limits the total of goroutines to a given number by recycling goroutines.
 type Pool struct {
+	// inflightBytes is the estimated memory held by the in-flight tasks, it's placed first along with the other
+	// 64-bit counters to guarantee the 64-bit alignment required by atomic operations on 32-bit platforms.
+	inflightBytes uint64
+
+	// rejected is the number of submissions refused with ErrPoolOverload.
+	rejected uint64
+
+	// nextWorkerID is the ID of the last worker spawned, which is reported by pool.ForEachWorker().
+	nextWorkerID uint64
+
+	// breaches is the number of tasks which waited for a worker longer than MaxQueueLatency.
+	breaches uint64
+
+	// submitted and completed are the numbers of tasks accepted and completed, peakRunning is the most workers
+	// running at once, they're kept across pool.Reboot() unless Options.ResetCountersOnReboot is set.
+	submitted uint64
+	completed uint64
+
+	// execTime is the sum of the execution time of the completed tasks in nanoseconds.
+	execTime uint64
+
+	// capacityCheckedAt is when the capacity was last refreshed from Options.DynamicCapacity, in unix nanoseconds.
+	capacityCheckedAt int64
+
+	// nextSpawnAt is when the next worker is allowed to be spawned under Options.SpawnRate, in unix nanoseconds.
+	nextSpawnAt int64
+
+	// heapAlloc is the heap usage of the process sampled every tick under Options.MemoryPressureLimit.
+	heapAlloc uint64
+
 	// capacity of the pool, a negative value means that the capacity of pool is limitless, an infinite pool is used to
 	// avoid potential issue of endless blocking caused by nested usage of a pool: submitting a task to pool
 	// which submits a new task to the same pool.
 	capacity int32
 
+	peakRunning int32
+
 	// running is the number of the currently running goroutines.
 	running int32
 
+	// goroutines is the number of all goroutines started by this pool that haven't exited yet.
+	goroutines int32
+
 	// lock for protecting the worker queue.
 	lock sync.Locker
 
@@ -50,6 +90,10 @@ type Pool struct {
 	// state is used to notice the pool to closed itself.
 	state int32
 
+	// draining is set to 1 by pool.ReleaseDrainQueue() to reject the new submissions before the pool gets closed,
+	// or to 2 by pool.Quiesce() to reject them until the running tasks are done.
+	draining int32
+
 	// cond for waiting to get an idle worker.
 	cond *sync.Cond
 
@@ -59,20 +103,123 @@ type Pool struct {
 	// waiting is the number of goroutines already been blocked on pool.Submit(), protected by pool.lock
 	waiting int32
 
+	// pending holds the *pendingTask of the goroutines blocked on pool.Submit(), protected by pool.lock.
+	pending *list.List
+
+	// spawning holds the *pendingTask of the goroutines waiting for their turns under Options.SpawnRate,
+	// protected by pool.lock.
+	spawning *list.List
+
+	// yielded holds the rest of the steps of the tasks yielded by pool.SubmitYielding(), protected by pool.lock.
+	yielded *list.List
+
+	// numYielded is the length of pool.yielded, which lets the workers skip the lock when it's empty.
+	numYielded int32
+
+	// saturated indicates whether all workers up to the capacity are busy, it's only maintained
+	// after watchSaturation is set by the first call to SaturationSignal() or DesaturationSignal(), or by OnFull.
+	saturated          int32
+	watchSaturation    int32
+	saturationSignal   chan struct{}
+	desaturationSignal chan struct{}
+
+	// onFullCalled indicates whether Options.OnFull has been called in the current saturation episode.
+	onFullCalled int32
+
+	// availability receives a value whenever a worker is put back into the worker queue or exits.
+	availability chan struct{}
+
+	// stopped receives a value whenever the number of running workers drops, it's waited on by ReleaseInOrder().
+	stopped chan struct{}
+
+	// inflightKeys is the set of keys of the in-flight tasks submitted by pool.SubmitOnce().
+	inflightKeys sync.Map
+
 	purgeDone int32
 	stopPurge context.CancelFunc
 
 	ticktockDone int32
 	stopTicktock context.CancelFunc
 
+	// stopWatch stops watching the context of a pool created by NewPoolWithContext.
+	stopWatch context.CancelFunc
+
+	// autoRelease is the timer releasing the pool at Options.AutoReleaseAt, protected by pool.lock.
+	autoRelease *time.Timer
+
 	now atomic.Value
 
+	// userData stores the data attached to the pool by the user.
+	userData atomic.Value
+
+	// hot stores the *hotOptions replaced by pool.Reconfigure(), reconfigureLock serializes the replacements.
+	hot             atomic.Value
+	reconfigureLock sync.Mutex
+
+	// boostLock serializes the changes of the capacity by pool.BoostCapacity().
+	boostLock sync.Mutex
+
+	// releaseHooks holds the callbacks registered by pool.OnRelease(), protected by releaseHooksLock.
+	releaseHooksLock sync.Mutex
+	releaseHooks     []func()
+
+	// scheduled holds the timers of the tasks scheduled by pool.SubmitAfter() by their IDs, protected by scheduleLock.
+	scheduleLock   sync.Mutex
+	scheduled      map[uint64]*time.Timer
+	nextScheduleID uint64
+
+	// classes schedules the tasks submitted by pool.SubmitClass().
+	classes *classScheduler
+
+	// events is the channel of the transition events, it's nil unless EventsBuffer is set.
+	events chan PoolEvent
+
+	// cpu is the semaphore of the CPU-bound sections, it's nil unless CPUBound is set.
+	cpu chan struct{}
+
+	// live is the set of the workers currently spawned.
+	live sync.Map
+
+	// callers is the set of the callers who submitted the running tasks, it's only maintained under CaptureCaller.
+	callers sync.Map
+
+	// cancels is the set of the *context.CancelFunc of the running tasks submitted by pool.SubmitWithContext().
+	cancels sync.Map
+
+	// clocks is the set of the task clocks of live workers, it's only maintained under StuckTaskDump.
+	clocks sync.Map
+
+	// latency is the histogram of the execution time of tasks, it's nil unless LatencyTracking is set.
+	latency *latencyHistogram
+
+	// manager runs the purge and the ticktock of the pool instead of its own goroutines, if it's not nil.
+	manager *PoolManager
+
 	options *Options
 }
 
+// pendingTask is the task of a goroutine blocked on pool.Submit() or waiting for its turn to spawn a worker.
+type pendingTask struct {
+	// task is the task as submitted by the caller, before it's wrapped by pool.admit().
+	task func()
+
+	// dst is the pool this task has been moved to by Pool.DrainTo().
+	dst *Pool
+}
+
+// hotOptions is the snapshot of the options which can be changed on a running pool by Pool.Reconfigure(),
+// they're read from here rather than from the Options of the pool.
+type hotOptions struct {
+	panicHandler func(interface{})
+	logger       Logger
+	expiry       time.Duration
+}
+
 // purgeStaleWorkers clears stale workers periodically, it runs in an individual goroutine, as a scavenger.
 func (p *Pool) purgeStaleWorkers(ctx context.Context) {
-	ticker := time.NewTicker(p.options.ExpiryDuration)
+	defer p.addGoroutines(-1)
+	expiry := p.hotOptions().expiry
+	ticker := time.NewTicker(expiry)
 
 	defer func() {
 		ticker.Stop()
@@ -90,32 +237,47 @@ func (p *Pool) purgeStaleWorkers(ctx context.Context) {
 			break
 		}
 
-		var isDormant bool
-		p.lock.Lock()
-		staleWorkers := p.workers.refresh(p.options.ExpiryDuration)
-		n := p.Running()
-		isDormant = n == 0 || n == len(staleWorkers)
-		p.lock.Unlock()
+		p.purge()
 
-		// Notify obsolete workers to stop.
-		// This notification must be outside the p.lock, since w.task
-		// may be blocking and may consume a lot of time if many workers
-		// are located on non-local CPUs.
-		for i := range staleWorkers {
-			staleWorkers[i].finish()
-			staleWorkers[i] = nil
+		// Follow the expiry changed by Reconfigure from the next purge on.
+		if e := p.hotOptions().expiry; e != expiry {
+			ticker.Stop()
+			expiry = e
+			ticker = time.NewTicker(expiry)
 		}
+	}
+}
 
-		// There might be a situation where all workers have been cleaned up(no worker is running),
-		// while some invokers still are stuck in "p.cond.Wait()", then we need to awake those invokers.
-		if isDormant && p.Waiting() > 0 {
-			p.cond.Broadcast()
-		}
+// purge stops the workers which have been idle for longer than ExpiryDuration.
+func (p *Pool) purge() {
+	var isDormant bool
+	p.lock.Lock()
+	staleWorkers := p.workers.refresh(p.hotOptions().expiry)
+	n := p.Running()
+	isDormant = n == 0 || n == len(staleWorkers)
+	p.lock.Unlock()
+
+	// Notify obsolete workers to stop.
+	// This notification must be outside the p.lock, since w.task
+	// may be blocking and may consume a lot of time if many workers
+	// are located on non-local CPUs.
+	for i := range staleWorkers {
+		staleWorkers[i].finish()
+		staleWorkers[i] = nil
+	}
+
+	// There might be a situation where all workers have been cleaned up(no worker is running),
+	// while some invokers still are stuck in "p.cond.Wait()", then we need to awake those invokers.
+	if isDormant && p.Waiting() > 0 {
+		p.cond.Broadcast()
 	}
 }
 
-// ticktock is a goroutine that updates the current time in the pool regularly.
+// ticktock is a goroutine that updates the current time in the pool regularly,
+// it also checks whether the pool stays saturated for WithSaturationAlert and dumps the stuck tasks for WithStuckTaskDump.
 func (p *Pool) ticktock(ctx context.Context) {
+	defer p.addGoroutines(-1)
+	var alert saturationAlert
 	ticker := time.NewTicker(nowTimeUpdateInterval)
 	defer func() {
 		ticker.Stop()
@@ -133,25 +295,67 @@ func (p *Pool) ticktock(ctx context.Context) {
 			break
 		}
 
-		p.now.Store(time.Now())
+		p.tick(time.Now(), &alert)
+	}
+}
+
+// tick updates the current time in the pool and runs the periodic checks.
+func (p *Pool) tick(now time.Time, alert *saturationAlert) {
+	p.now.Store(now)
+
+	if p.options.MemoryPressureLimit > 0 {
+		p.sampleHeap()
+	}
+
+	if p.options.SaturationAlert != nil {
+		alert.observe(now, p.Free() == 0 && p.Waiting() > 0, p.options)
+	}
+
+	if p.options.StuckTaskDump > 0 {
+		dumpStuckTasks(&p.clocks, now, p.options.StuckTaskDump, p.hotOptions().logger)
 	}
 }
 
+// sampleHeap samples the heap usage of the process for Options.MemoryPressureLimit.
+func (p *Pool) sampleHeap() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	atomic.StoreUint64(&p.heapAlloc, stats.HeapAlloc)
+}
+
 func (p *Pool) goPurge() {
 	if p.options.DisablePurge {
 		return
 	}
 
+	if p.manager != nil {
+		// The manager purges this pool along with the ticktock, as long as it's registered.
+		p.stopPurge = func() {
+			atomic.StoreInt32(&p.purgeDone, 1)
+		}
+		return
+	}
+
 	// Start a goroutine to clean up expired workers periodically.
 	var ctx context.Context
 	ctx, p.stopPurge = context.WithCancel(context.Background())
+	p.addGoroutines(1)
 	go p.purgeStaleWorkers(ctx)
 }
 
 func (p *Pool) goTicktock() {
 	p.now.Store(time.Now())
+	if m := p.manager; m != nil {
+		m.register(p)
+		p.stopTicktock = func() {
+			m.deregister(p)
+			atomic.StoreInt32(&p.ticktockDone, 1)
+		}
+		return
+	}
 	var ctx context.Context
 	ctx, p.stopTicktock = context.WithCancel(context.Background())
+	p.addGoroutines(1)
 	go p.ticktock(ctx)
 }
 
@@ -161,12 +365,42 @@ func (p *Pool) nowTime() time.Time {
 
 // NewPool generates an instance of ants pool.
 func NewPool(size int, options ...Option) (*Pool, error) {
-	if size <= 0 {
-		size = -1
+	return newPool(size, nil, options...)
+}
+
+// NewPoolWithContext is like NewPool, but the pool is released once ctx is done, which ties the lifetime of the pool
+// to a request or a server. The goroutine watching ctx exits once the pool is released, and a rebooted pool
+// is no longer tied to ctx.
+func NewPoolWithContext(ctx context.Context, size int, options ...Option) (*Pool, error) {
+	p, err := NewPool(size, options...)
+	if err != nil {
+		return nil, err
 	}
+	ctx, p.stopWatch = context.WithCancel(ctx)
+	p.addGoroutines(1)
+	go func() {
+		defer p.addGoroutines(-1)
+		<-ctx.Done()
+		p.Release()
+	}()
+	return p, nil
+}
 
+func newPool(size int, manager *PoolManager, options ...Option) (*Pool, error) {
 	opts := loadOptions(options...)
 
+	if size <= 0 && opts.CapacityPerCPU > 0 {
+		size = opts.CapacityPerCPU * runtime.GOMAXPROCS(0)
+	}
+	if opts.DynamicCapacity != nil {
+		if n := opts.DynamicCapacity(); n > 0 {
+			size = n
+		}
+	}
+	if size <= 0 {
+		size = -1
+	}
+
 	if !opts.DisablePurge {
 		if expiry := opts.ExpiryDuration; expiry < 0 {
 			return nil, ErrInvalidPoolExpiry
@@ -180,9 +414,20 @@ func NewPool(size int, options ...Option) (*Pool, error) {
 	}
 
 	p := &Pool{
+		capacityCheckedAt: time.Now().UnixNano(),
+
 		capacity: int32(size),
 		lock:     syncx.NewSpinLock(),
+		pending:  list.New(),
+		spawning: list.New(),
+		yielded:  list.New(),
+		manager:  manager,
 		options:  opts,
+
+		saturationSignal:   make(chan struct{}, 1),
+		desaturationSignal: make(chan struct{}, 1),
+		availability:       make(chan struct{}, 1),
+		stopped:            make(chan struct{}, 1),
 	}
 	p.workerCache.New = func() interface{} {
 		return &goWorker{
@@ -196,17 +441,75 @@ func NewPool(size int, options ...Option) (*Pool, error) {
 		}
 		p.workers = newWorkerQueue(queueTypeLoopQueue, size)
 	} else {
-		p.workers = newWorkerQueue(queueTypeStack, 0)
+		p.workers = newWorkerQueue(queueTypeStack, p.options.InitialWorkerCap)
 	}
 
 	p.cond = sync.NewCond(p.lock)
+	if p.options.OnFull != nil {
+		p.watchSaturation = 1
+	}
+	p.hot.Store(&hotOptions{panicHandler: opts.PanicHandler, logger: opts.Logger, expiry: opts.ExpiryDuration})
+
+	if p.options.LatencyTracking {
+		p.latency = new(latencyHistogram)
+	}
+
+	if p.options.CPUBound > 0 {
+		p.cpu = make(chan struct{}, p.options.CPUBound)
+	}
+
+	p.classes = newClassScheduler(p)
+
+	if p.options.EventsBuffer > 0 {
+		p.events = make(chan PoolEvent, p.options.EventsBuffer)
+	}
+
+	if p.options.MemoryPressureLimit > 0 {
+		p.sampleHeap()
+	}
 
 	p.goPurge()
 	p.goTicktock()
 
+	if at := p.options.AutoReleaseAt; !at.IsZero() {
+		// A past time fires the timer at once, so it's assigned within the lock scope release() reads it in.
+		p.lock.Lock()
+		p.autoRelease = time.AfterFunc(time.Until(at), func() {
+			p.addGoroutines(1)
+			defer p.addGoroutines(-1)
+			p.Release()
+		})
+		p.lock.Unlock()
+	}
+
+	if p.options.Validate {
+		if err := p.validate(); err != nil {
+			p.Release()
+			return nil, err
+		}
+	}
+
 	return p, nil
 }
 
+// validate runs a no-op probe on a fresh worker of the pool for WithValidate. The probe isn't a task
+// of the user, so it's handed to the worker directly rather than through admit() and the hooks.
+func (p *Pool) validate() error {
+	probe := func() {}
+	w, _, _ := p.retrieveWorker(probe)
+	if w == nil {
+		return ErrPoolOverload
+	}
+	done := make(chan struct{})
+	w.(*goWorker).probe = true
+	w.inputFunc(func() {
+		probe()
+		close(done)
+	})
+	<-done
+	return nil
+}
+
 // ---------------------------------------------------------------------------
 
 // Submit submits a task to this pool.
@@ -214,185 +517,1674 @@ func NewPool(size int, options ...Option) (*Pool, error) {
 // Note that you are allowed to call Pool.Submit() from the current Pool.Submit(),
 // but what calls for special attention is that you will get blocked with the latest
 // Pool.Submit() call once the current Pool runs out of its capacity, and to avoid this,
-// you should instantiate a Pool with ants.WithNonblocking(true).
+// you should instantiate a Pool with ants.WithNonblocking(true) or use Pool.SubmitReentrant().
 func (p *Pool) Submit(task func()) error {
-	if p.IsClosed() {
-		return ErrPoolClosed
-	}
-	if w := p.retrieveWorker(); w != nil {
-		w.inputFunc(task)
-		return nil
-	}
-	return ErrPoolOverload
+	_, _, err := p.submit(context.Background(), task, "")
+	return err
 }
 
-// Running returns the number of workers currently running.
-func (p *Pool) Running() int {
-	return int(atomic.LoadInt32(&p.running))
+// SubmitAdmission is like Submit, but also reports how the submission was handled.
+func (p *Pool) SubmitAdmission(task func()) (AdmissionResult, error) {
+	result, _, err := p.submit(context.Background(), task, "")
+	return result, err
 }
 
-// Free returns the number of available goroutines to work, -1 indicates this pool is unlimited.
-func (p *Pool) Free() int {
-	c := p.Cap()
-	if c < 0 {
-		return -1
-	}
-	return c - p.Running()
+// SubmitJob submits a job to this pool, its Run method is called on a worker.
+func (p *Pool) SubmitJob(j Job) error {
+	return p.Submit(j.Run)
 }
 
-// Waiting returns the number of tasks which are waiting be executed.
-func (p *Pool) Waiting() int {
-	return int(atomic.LoadInt32(&p.waiting))
+// SubmitBatchLimited submits a batch of tasks to this pool and waits for all of them to complete,
+// running at most maxConcurrent of them at the same time regardless of the capacity of this pool,
+// a non-positive maxConcurrent means that the batch is only limited by the capacity.
+// It stops submitting the rest of the batch on the first error, which is returned after
+// the submitted tasks have completed.
+func (p *Pool) SubmitBatchLimited(tasks []func(), maxConcurrent int) (err error) {
+	if maxConcurrent <= 0 || maxConcurrent > len(tasks) {
+		maxConcurrent = len(tasks)
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrent)
+	for _, task := range tasks {
+		sem <- struct{}{}
+		wg.Add(1)
+		task := task
+		if err = p.Submit(func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			task()
+		}); err != nil {
+			wg.Done()
+			break
+		}
+	}
+	wg.Wait()
+	return
 }
 
-// Cap returns the capacity of this pool.
-func (p *Pool) Cap() int {
-	return int(atomic.LoadInt32(&p.capacity))
+// RunCPUBound runs fn, the CPU-bound section of a task, once fewer than CPUBound tasks are
+// in their CPU-bound sections, it runs fn directly if CPUBound is not set.
+func (p *Pool) RunCPUBound(fn func()) {
+	if p.cpu != nil {
+		p.cpu <- struct{}{}
+		defer func() {
+			<-p.cpu
+		}()
+	}
+	fn()
 }
 
-// Tune changes the capacity of this pool, note that it is noneffective to the infinite or pre-allocation pool.
-func (p *Pool) Tune(size int) {
-	capacity := p.Cap()
-	if capacity == -1 || size <= 0 || size == capacity || p.options.PreAlloc {
-		return
+// SubmitAfter schedules a task to be submitted to this pool after the delay, it returns the ID
+// of the scheduled task for CancelScheduled. The error of submitting the task when the delay elapses
+// is dropped, and the scheduled tasks are cancelled once the pool is released.
+func (p *Pool) SubmitAfter(delay time.Duration, task func()) (uint64, error) {
+	if p.IsClosed() {
+		return 0, ErrPoolClosed
 	}
-	atomic.StoreInt32(&p.capacity, int32(size))
-	if size > capacity {
-		if size-capacity == 1 {
-			p.cond.Signal()
-			return
-		}
-		p.cond.Broadcast()
+	p.scheduleLock.Lock()
+	defer p.scheduleLock.Unlock()
+	if p.scheduled == nil {
+		p.scheduled = make(map[uint64]*time.Timer)
 	}
+	p.nextScheduleID++
+	id := p.nextScheduleID
+	p.scheduled[id] = time.AfterFunc(delay, func() {
+		p.addGoroutines(1)
+		defer p.addGoroutines(-1)
+		p.scheduleLock.Lock()
+		_, ok := p.scheduled[id]
+		delete(p.scheduled, id)
+		p.scheduleLock.Unlock()
+		if ok {
+			_ = p.Submit(task)
+		}
+	})
+	return id, nil
 }
 
-// IsClosed indicates whether the pool is closed.
-func (p *Pool) IsClosed() bool {
-	return atomic.LoadInt32(&p.state) == CLOSED
+// ScheduledCount returns the number of the tasks scheduled by SubmitAfter which haven't fired yet.
+func (p *Pool) ScheduledCount() int {
+	p.scheduleLock.Lock()
+	defer p.scheduleLock.Unlock()
+	return len(p.scheduled)
 }
 
-// Release closes this pool and releases the worker queue.
-func (p *Pool) Release() {
-	if !atomic.CompareAndSwapInt32(&p.state, OPENED, CLOSED) {
-		return
+// CancelScheduled cancels a task scheduled by SubmitAfter, it reports whether the task was cancelled before firing.
+func (p *Pool) CancelScheduled(id uint64) bool {
+	p.scheduleLock.Lock()
+	defer p.scheduleLock.Unlock()
+	timer, ok := p.scheduled[id]
+	if ok {
+		timer.Stop()
+		delete(p.scheduled, id)
 	}
+	return ok
+}
 
-	if p.stopPurge != nil {
-		p.stopPurge()
-		p.stopPurge = nil
+// SubmitTraced submits a task to this pool and waits for it to complete, it returns the timing breakdown
+// of the task, which reveals how much time it spent queueing versus executing.
+func (p *Pool) SubmitTraced(task func()) (trace Trace, err error) {
+	done := make(chan struct{})
+	trace.QueuedAt = time.Now()
+	if err = p.Submit(func() {
+		defer func() {
+			trace.FinishedAt = time.Now()
+			close(done)
+		}()
+		trace.StartedAt = time.Now()
+		task()
+	}); err != nil {
+		return
 	}
-	p.stopTicktock()
-	p.stopTicktock = nil
-
-	p.lock.Lock()
-	p.workers.reset()
-	p.lock.Unlock()
-	// There might be some callers waiting in retrieveWorker(), so we need to wake them up to prevent
-	// those callers blocking infinitely.
-	p.cond.Broadcast()
+	<-done
+	return
 }
 
-// ReleaseTimeout is like Release but with a timeout, it waits all workers to exit before timing out.
-func (p *Pool) ReleaseTimeout(timeout time.Duration) error {
-	if p.IsClosed() || (!p.options.DisablePurge && p.stopPurge == nil) || p.stopTicktock == nil {
+// SubmitClass submits a task of the class to this pool, when the pool is saturated, the workers are distributed
+// across the classes in proportion to the weights set up by WithClassWeights rather than in the order
+// of submission, which prevents a noisy class from monopolizing the pool. The submitters waiting for
+// a worker are counted by Waiting, and they're subject to Nonblocking and MaxBlockingTasks like Submit.
+func (p *Pool) SubmitClass(class string, task func()) error {
+	if p.IsClosed() {
 		return ErrPoolClosed
 	}
-	p.Release()
-
-	endTime := time.Now().Add(timeout)
-	for time.Now().Before(endTime) {
-		if p.Running() == 0 &&
-			(p.options.DisablePurge || atomic.LoadInt32(&p.purgeDone) == 1) &&
-			atomic.LoadInt32(&p.ticktockDone) == 1 {
-			return nil
-		}
-		time.Sleep(10 * time.Millisecond)
-	}
-	return ErrTimeout
+	return p.classes.submit(class, task)
 }
 
-// Reboot reboots a closed pool.
-func (p *Pool) Reboot() {
-	if atomic.CompareAndSwapInt32(&p.state, CLOSED, OPENED) {
-		atomic.StoreInt32(&p.purgeDone, 0)
-		p.goPurge()
-		atomic.StoreInt32(&p.ticktockDone, 0)
-		p.goTicktock()
-	}
+// SubmitWithContext submits a context-aware task to this pool, the task is passed a context derived from ctx,
+// which is also cancelled by CancelAll while the task is running. ctx is passed to the Admitter as well.
+func (p *Pool) SubmitWithContext(ctx context.Context, task func(context.Context)) error {
+	_, _, err := p.submit(ctx, func() {
+		ctx, cancel := context.WithCancel(ctx)
+		p.cancels.Store(&cancel, struct{}{})
+		defer func() {
+			p.cancels.Delete(&cancel)
+			cancel()
+		}()
+		task(ctx)
+	}, "")
+	return err
 }
 
-// ---------------------------------------------------------------------------
+// CancelAll cancels the contexts of all running tasks submitted by SubmitWithContext, signaling them to abort,
+// which is meant for shedding the load in an emergency. The tasks ignoring their contexts keep running.
+func (p *Pool) CancelAll() {
+	p.cancels.Range(func(key, _ interface{}) bool {
+		(*key.(*context.CancelFunc))()
+		return true
+	})
+}
 
-func (p *Pool) addRunning(delta int) {
-	atomic.AddInt32(&p.running, int32(delta))
+// SubmitCallback submits a task to this pool without waiting for it, done is called on the worker
+// after the task completes, it's not called if the task panics. A panic from done is recovered and passed
+// to the PanicHandler of this pool, or logged if there is no PanicHandler, so the worker survives.
+func (p *Pool) SubmitCallback(task func(), done func()) error {
+	return p.Submit(func() {
+		task()
+		p.runCallback(done)
+	})
 }
 
-func (p *Pool) addWaiting(delta int) {
-	atomic.AddInt32(&p.waiting, int32(delta))
+// SubmitCallbackErr is like SubmitCallback, but done receives the error from the task,
+// or a *PanicError if the task panicked.
+func (p *Pool) SubmitCallbackErr(task func() error, done func(error)) error {
+	return p.Submit(func() {
+		var err error
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					err = &PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}()
+			err = task()
+		}()
+		p.report(err)
+		p.runCallback(func() { done(err) })
+	})
 }
 
-// retrieveWorker returns an available worker to run the tasks.
-func (p *Pool) retrieveWorker() (w worker) {
-	spawnWorker := func() {
-		w = p.workerCache.Get().(*goWorker)
-		w.run()
-	}
+// SubmitBackoff submits a task which is retried per the policy as long as it returns an error,
+// each retry is scheduled by SubmitAfter rather than slept on, so no worker is held during the delays.
+// It only returns the error of submitting the first attempt, the error of the last attempt is logged.
+func (p *Pool) SubmitBackoff(task func() error, policy BackoffPolicy) error {
+	return p.Submit(p.backoffTask(task, policy, 1, policy.InitialDelay))
+}
 
-	p.lock.Lock()
-	w = p.workers.detach()
-	if w != nil { // first try to fetch the worker from the queue
-		p.lock.Unlock()
-	} else if capacity := p.Cap(); capacity == -1 || capacity > p.Running() {
-		// if the worker queue is empty and we don't run out of the pool capacity,
-		// then just spawn a new worker goroutine.
-		p.lock.Unlock()
-		spawnWorker()
-	} else { // otherwise, we'll have to keep them blocked and wait for at least one worker to be put back into pool.
-		if p.options.Nonblocking {
-			p.lock.Unlock()
+func (p *Pool) backoffTask(task func() error, policy BackoffPolicy, attempt int, delay time.Duration) func() {
+	return func() {
+		err := task()
+		p.report(err)
+		if err == nil {
 			return
 		}
-	retry:
-		if p.options.MaxBlockingTasks != 0 && p.Waiting() >= p.options.MaxBlockingTasks {
-			p.lock.Unlock()
+		if attempt >= policy.MaxAttempts {
+			p.hotOptions().logger.Printf("task fails after %d attempts: %v\n", attempt, err)
 			return
 		}
-
-		p.addWaiting(1)
-		p.cond.Wait() // block and wait for an available worker
-		p.addWaiting(-1)
-
-		if p.IsClosed() {
-			p.lock.Unlock()
-			return
+		if _, err := p.SubmitAfter(delay, p.backoffTask(task, policy, attempt+1, policy.next(delay))); err != nil {
+			p.hotOptions().logger.Printf("task can not be retried: %v\n", err)
 		}
+	}
+}
 
-		if w = p.workers.detach(); w == nil {
-			if p.Free() > 0 {
-				p.lock.Unlock()
-				spawnWorker()
+// SubmitYielding submits a long task structured as resumable steps, step is called repeatedly
+// until it returns true. Between two steps, the task yields its worker to the tasks waiting for one,
+// the rest of the steps are resumed on a worker once none is waiting, so that a marathon task
+// doesn't hold a worker ahead of them. The task is submitted and counted only once, the rest of
+// the steps are dropped if this pool is closed before they're resumed.
+func (p *Pool) SubmitYielding(step func() (done bool)) error {
+	return p.Submit(p.yieldingTask(step))
+}
+
+func (p *Pool) yieldingTask(step func() bool) func() {
+	var task func()
+	task = func() {
+		for !step() {
+			if p.yield(task) {
 				return
 			}
-			goto retry
 		}
-		p.lock.Unlock()
 	}
-	return
+	return task
 }
 
-// revertWorker puts a worker back into free pool, recycling the goroutines.
-func (p *Pool) revertWorker(worker *goWorker) bool {
-	if capacity := p.Cap(); (capacity > 0 && p.Running() > capacity) || p.IsClosed() {
-		p.cond.Broadcast()
+// yield queues the rest of a yielding task behind the tasks waiting for a worker,
+// it reports false if none is waiting, in which case the task keeps its worker.
+func (p *Pool) yield(rest func()) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.pending.Len() == 0 || p.IsClosed() {
 		return false
 	}
+	p.yielded.PushBack(rest)
+	atomic.AddInt32(&p.numYielded, 1)
+	return true
+}
 
-	worker.lastUsed = p.nowTime()
+// nextYielded takes the rest of a yielded task once no task is waiting for a worker, protected by pool.lock.
+func (p *Pool) nextYielded() func() {
+	if p.pending.Len() > 0 || p.yielded.Len() == 0 {
+		return nil
+	}
+	atomic.AddInt32(&p.numYielded, -1)
+	return p.yielded.Remove(p.yielded.Front()).(func())
+}
+
+// dropYielded drops the rest of the yielded tasks once this pool is closed, protected by pool.lock.
+func (p *Pool) dropYielded() {
+	p.yielded.Init()
+	atomic.StoreInt32(&p.numYielded, 0)
+}
 
+// resumeYielded hands the yielded tasks over to the idle workers, or to new ones within the capacity,
+// it's called once a worker stops, so that they aren't left behind with no busy worker to resume them.
+func (p *Pool) resumeYielded() {
+	if atomic.LoadInt32(&p.numYielded) == 0 {
+		return
+	}
 	p.lock.Lock()
-	// To avoid memory leaks, add a double check in the lock scope.
-	// Issue: https://github.com/panjf2000/ants/issues/113
 	if p.IsClosed() {
+		p.dropYielded()
+		p.lock.Unlock()
+		return
+	}
+	for rest := p.nextYielded(); rest != nil; rest = p.nextYielded() {
+		w, _ := p.workers.detach().(*goWorker)
+		if w == nil {
+			// The yielded task has been admitted already, don't keep it waiting for SpawnRate.
+			if capacity := p.Cap(); capacity != -1 && capacity <= p.Running() {
+				p.yielded.PushFront(rest)
+				atomic.AddInt32(&p.numYielded, 1)
+				break
+			}
+			w = p.workerCache.Get().(*goWorker)
+			w.run()
+		}
+		w.resumed = true
+		p.lock.Unlock()
+		w.inputFunc(rest)
+		p.lock.Lock()
+	}
+	p.lock.Unlock()
+}
+
+// SubmitTimed is like Submit, but also reports how long the caller was blocked waiting for
+// an available worker, zero means that a worker was available at once.
+func (p *Pool) SubmitTimed(task func()) (waited time.Duration, err error) {
+	_, waited, err = p.submit(context.Background(), task, "")
+	return
+}
+
+// SubmitTagged is like Submit, but the task is tagged for diagnostics,
+// the worker running it reports the tag through ForEachWorker.
+func (p *Pool) SubmitTagged(tag string, task func()) error {
+	_, _, err := p.submit(context.Background(), task, tag)
+	return err
+}
+
+// SubmitWithRecover is like Submit, but a panic from the task is recovered and passed to onPanic
+// instead of the PanicHandler of this pool, and the worker survives the panic.
+func (p *Pool) SubmitWithRecover(task func(), onPanic func(interface{})) error {
+	if onPanic == nil {
+		return p.Submit(task)
+	}
+	return p.Submit(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				onPanic(r)
+			}
+		}()
+		task()
+	})
+}
+
+// SubmitOnce submits a task to this pool unless another task with the same key is still in flight,
+// it reports whether the task was actually submitted or deduplicated. The key is forgotten once the task completes.
+func (p *Pool) SubmitOnce(key string, task func()) (bool, error) {
+	if _, loaded := p.inflightKeys.LoadOrStore(key, struct{}{}); loaded {
+		return false, nil
+	}
+	err := p.Submit(func() {
+		defer p.inflightKeys.Delete(key)
+		task()
+	})
+	if err != nil {
+		p.inflightKeys.Delete(key)
+		return false, err
+	}
+	return true, nil
+}
+
+// SubmitSafe submits a task to this pool and waits for it to complete, it returns the error from the task,
+// or a *PanicError if the task panicked, so that the caller can handle both of them in the same way.
+func (p *Pool) SubmitSafe(task func() error) error {
+	errCh := make(chan error, 1)
+	if err := p.Submit(func() {
+		var err error
+		defer func() {
+			if r := recover(); r != nil {
+				err = &PanicError{Value: r, Stack: debug.Stack()}
+			}
+			p.report(err)
+			errCh <- err
+		}()
+		err = task()
+	}); err != nil {
+		return err
+	}
+	return <-errCh
+}
+
+// submit dispatches the task to a worker, it reports how the task was handled,
+// and how long the caller was blocked waiting for a worker. ctx is passed to the Admitter.
+func (p *Pool) submit(ctx context.Context, task func(), tag string) (AdmissionResult, time.Duration, error) {
+	return p.submitAs(ctx, task, task, tag)
+}
+
+// submitAs is like submit, but Pool.ShutdownNow() hands back submitted instead of task if it never starts,
+// which is the task of the caller when task is an internal wrapper of it.
+func (p *Pool) submitAs(ctx context.Context, task, submitted func(), tag string) (AdmissionResult, time.Duration, error) {
+	if p.IsClosed() {
+		return Rejected, 0, ErrPoolClosed
+	}
+	admitted, a, err := p.admit(ctx, task)
+	if err != nil {
+		return Rejected, 0, err
+	}
+	if p.options.Synchronous {
+		p.taskSubmitted()
+		p.addRunning(1)
+		defer func() {
+			p.addRunning(-1)
+			notify(p.stopped)
+		}()
+		defer p.taskCompleted()
+		p.runInline(admitted)
+		return Admitted, 0, nil
+	}
+	var (
+		w      worker
+		waited time.Duration
+		dst    *Pool
+	)
+	draining := atomic.LoadInt32(&p.draining) == 1
+	if draining {
+		// The submissions accepted under AcceptDuringDrain only take the free capacity,
+		// they never queue up behind the tasks being drained.
+		w = p.tryRetrieveWorker(0)
+	} else {
+		w, waited, dst = p.retrieveWorker(submitted)
+	}
+	if w != nil {
+		if tag != "" {
+			p.lock.Lock()
+			w.(*goWorker).tag = tag
+			p.lock.Unlock()
+		}
+		p.taskSubmitted()
+		w.inputFunc(admitted)
+		p.notifySaturation()
+		if limit := p.options.MaxQueueLatency; limit > 0 && waited > limit {
+			atomic.AddUint64(&p.breaches, 1)
+			if onBreach := p.options.OnQueueLatencyBreach; onBreach != nil {
+				p.runCallback(func() { onBreach(waited) })
+			}
+		}
+		if waited > 0 {
+			return Queued, waited, nil
+		}
+		return Admitted, waited, nil
+	}
+	p.revoke(a)
+	if dst != nil {
+		return Queued, waited, dst.Submit(task)
+	}
+	result := Rejected
+	if waited > 0 {
+		result = Blocked
+	}
+	if draining || p.IsClosed() {
+		return result, waited, ErrPoolClosed
+	}
+	atomic.AddUint64(&p.rejected, 1)
+	return result, waited, ErrPoolOverload
+}
+
+// SubmitReentrant submits a task to this pool, it runs the task inline on the calling goroutine
+// instead of blocking when no worker is available at once.
+//
+// It's meant for the tasks which submit subtasks to the same pool, since blocking on a saturated
+// pool from inside a worker can deadlock when all workers are waiting for themselves to be free.
+func (p *Pool) SubmitReentrant(task func()) error {
+	if p.IsClosed() {
+		return ErrPoolClosed
+	}
+	task, _, err := p.admit(nonblocking, task)
+	if err != nil {
+		return err
+	}
+	if w := p.tryRetrieveWorker(0); w != nil {
+		p.taskSubmitted()
+		w.inputFunc(task)
+		p.notifySaturation()
+		return nil
+	}
+	p.taskSubmitted()
+	defer p.taskCompleted()
+	task()
+	return nil
+}
+
+// SubmitIfAvailable submits a task to this pool only if there is an available worker at once, it never
+// blocks nor spawns workers beyond the capacity, and reports false without an error if the pool is saturated.
+func (p *Pool) SubmitIfAvailable(task func()) (bool, error) {
+	if p.IsClosed() {
+		return false, ErrPoolClosed
+	}
+	task, a, err := p.admit(nonblocking, task)
+	if err != nil {
+		return false, err
+	}
+	if w := p.tryRetrieveWorker(0); w != nil {
+		p.taskSubmitted()
+		w.inputFunc(task)
+		p.notifySaturation()
+		return true, nil
+	}
+	p.revoke(a)
+	return false, nil
+}
+
+// SubmitReserving submits a task to this pool only if at least reserve workers would be left available
+// after taking one for it, which keeps the headroom for the other submissions. It never blocks,
+// and returns ErrInsufficientHeadroom otherwise, an unlimited pool always has the headroom.
+func (p *Pool) SubmitReserving(task func(), reserve int) error {
+	if p.IsClosed() {
+		return ErrPoolClosed
+	}
+	task, a, err := p.admit(nonblocking, task)
+	if err != nil {
+		return err
+	}
+	if w := p.tryRetrieveWorker(reserve); w != nil {
+		p.taskSubmitted()
+		w.inputFunc(task)
+		p.notifySaturation()
+		return nil
+	}
+	p.revoke(a)
+	if p.IsClosed() {
+		return ErrPoolClosed
+	}
+	return ErrInsufficientHeadroom
+}
+
+// Running returns the number of workers currently running.
+func (p *Pool) Running() int {
+	return int(atomic.LoadInt32(&p.running))
+}
+
+// Free returns the number of available goroutines to work, -1 indicates this pool is unlimited.
+// It's taken from Snapshot, so it's 0 rather than negative while the pool shrinks below its running workers.
+func (p *Pool) Free() int {
+	_, _, free := p.Snapshot()
+	return free
+}
+
+// WouldBlock reports whether a Submit at this instant would have to wait for a worker, that is, there is
+// no idle worker and the capacity is used up, or the tasks already waiting go first under FIFOTasks.
+// It's only a hint, which may be outdated as soon as it returns. A closed pool never blocks.
+func (p *Pool) WouldBlock() bool {
+	if p.IsClosed() {
+		return false
+	}
+	p.lock.Lock()
+	idle := p.workers.len()
+	queued := p.options.FIFOTasks && p.pending.Len() > 0
+	p.lock.Unlock()
+	if queued {
+		return true
+	}
+	capacity := p.Cap()
+	return idle == 0 && capacity != -1 && p.Running() >= capacity
+}
+
+// Snapshot returns the capacity, the number of running workers and the number of available goroutines
+// of this pool as a consistent triple even if the pool is being tuned concurrently, free is never negative
+// and -1 indicates this pool is unlimited.
+func (p *Pool) Snapshot() (capacity, running, free int) {
+	capacity, running = p.Cap(), p.Running()
+	for {
+		// Retry until neither value has changed in the meantime, so that the pair is taken at one point
+		// even while the pool is being tuned or its workers come and go.
+		c, r := p.Cap(), p.Running()
+		if c == capacity && r == running {
+			break
+		}
+		capacity, running = c, r
+	}
+	if capacity < 0 {
+		return capacity, running, -1
+	}
+	if free = capacity - running; free < 0 {
+		free = 0
+	}
+	return
+}
+
+// TotalSubmitted returns the number of tasks accepted to run so far.
+func (p *Pool) TotalSubmitted() uint64 {
+	return atomic.LoadUint64(&p.submitted)
+}
+
+// TotalCompleted returns the number of tasks completed so far, including the ones which panicked.
+func (p *Pool) TotalCompleted() uint64 {
+	return atomic.LoadUint64(&p.completed)
+}
+
+// PeakRunning returns the most workers running at once so far.
+func (p *Pool) PeakRunning() int {
+	return int(atomic.LoadInt32(&p.peakRunning))
+}
+
+// TotalRejected returns the number of submissions refused with ErrPoolOverload so far.
+func (p *Pool) TotalRejected() uint64 {
+	return atomic.LoadUint64(&p.rejected)
+}
+
+// GoroutineCount returns the number of goroutines this pool currently owns, that is, the workers,
+// the goroutines purging the stale workers and updating the clock, the one watching the context of
+// NewPoolWithContext and the timers submitting the tasks of SubmitAfter, restoring the capacity after
+// BoostCapacity or releasing the pool at AutoReleaseAt while they fire, so that it can be reconciled
+// with runtime.NumGoroutine(). The goroutine of a PoolManager belongs to the manager rather than its pools.
+func (p *Pool) GoroutineCount() int {
+	return int(atomic.LoadInt32(&p.goroutines))
+}
+
+// SLABreaches returns the number of tasks which waited for a worker longer than MaxQueueLatency so far.
+func (p *Pool) SLABreaches() uint64 {
+	return atomic.LoadUint64(&p.breaches)
+}
+
+// Waiting returns the number of tasks which are waiting be executed.
+func (p *Pool) Waiting() int {
+	return int(atomic.LoadInt32(&p.waiting))
+}
+
+// LatencyHistogram returns the number of completed tasks per execution time bucket, keyed by
+// the inclusive upper bound of each bucket, it returns nil if LatencyTracking is not set.
+func (p *Pool) LatencyHistogram() map[time.Duration]uint64 {
+	if p.latency == nil {
+		return nil
+	}
+	return p.latency.snapshot()
+}
+
+// TotalExecTime returns the sum of the execution time of the completed tasks, it returns 0
+// unless ExecTimeTracking or LatencyTracking is set.
+func (p *Pool) TotalExecTime() time.Duration {
+	return time.Duration(atomic.LoadUint64(&p.execTime))
+}
+
+// SaturationSignal returns a channel that receives a value whenever this pool becomes saturated,
+// that is, all workers up to the capacity are busy, so that producers don't need to poll Pool.Free().
+// The signals never block the pool, a signal that hasn't been received yet absorbs the subsequent ones.
+func (p *Pool) SaturationSignal() <-chan struct{} {
+	atomic.StoreInt32(&p.watchSaturation, 1)
+	return p.saturationSignal
+}
+
+// DesaturationSignal returns a channel that receives a value whenever this pool becomes unsaturated again,
+// with the same semantics as SaturationSignal. A worker handed over to a waiting task doesn't desaturate it.
+func (p *Pool) DesaturationSignal() <-chan struct{} {
+	atomic.StoreInt32(&p.watchSaturation, 1)
+	return p.desaturationSignal
+}
+
+// AvailabilityChan returns a channel that receives a value whenever a worker gets available, that is,
+// a worker finished its task and was put back into the pool, or a worker exited and freed up the capacity.
+// It's edge-triggered and never blocks the pool, a value that hasn't been received yet absorbs the subsequent ones,
+// so a receive only hints that a worker was available, it doesn't reserve one.
+func (p *Pool) AvailabilityChan() <-chan struct{} {
+	return p.availability
+}
+
+// InflightCallers returns the callers who submitted the tasks currently running, it's always empty
+// unless CaptureCaller is set.
+func (p *Pool) InflightCallers() []runtime.Frame {
+	var callers []runtime.Frame
+	p.callers.Range(func(key, _ interface{}) bool {
+		callers = append(callers, *key.(*runtime.Frame))
+		return true
+	})
+	return callers
+}
+
+// ForEachWorker calls fn with a read-only snapshot of each of the workers currently spawned, the snapshots are
+// taken within the lock scope before fn is called, so fn may call the methods of this pool.
+func (p *Pool) ForEachWorker(fn func(WorkerInfo)) {
+	now := time.Now()
+	var infos []WorkerInfo
+	p.lock.Lock()
+	p.live.Range(func(key, _ interface{}) bool {
+		w := key.(*goWorker)
+		infos = append(infos, WorkerInfo{
+			ID:     atomic.LoadUint64(&w.id),
+			Busy:   atomic.LoadInt32(&w.busy) == 1,
+			Tag:    w.tag,
+			Age:    now.Sub(time.Unix(0, atomic.LoadInt64(&w.spawnedAt))),
+			Served: atomic.LoadUint64(&w.served),
+		})
+		return true
+	})
+	p.lock.Unlock()
+	for _, info := range infos {
+		fn(info)
+	}
+}
+
+// SetUserData attaches arbitrary data to this pool, such as a tag or a config, which can be retrieved by UserData.
+func (p *Pool) SetUserData(data interface{}) {
+	p.userData.Store(userData{data})
+}
+
+// UserData returns the data attached to this pool by SetUserData, or nil if there is none.
+func (p *Pool) UserData() interface{} {
+	if data, ok := p.userData.Load().(userData); ok {
+		return data.value
+	}
+	return nil
+}
+
+// Cap returns the capacity of this pool.
+func (p *Pool) Cap() int {
+	return int(atomic.LoadInt32(&p.capacity))
+}
+
+// Reconfigure applies fn to a copy of the current options of this pool and puts the PanicHandler, the Logger
+// and the ExpiryDuration it sets into effect at once, so that the workers never observe a mix of the old and
+// the new settings. The changes of the other options are ignored, the new ExpiryDuration takes effect from
+// the next purge on. It returns ErrInvalidPoolExpiry without changing anything for a negative ExpiryDuration.
+func (p *Pool) Reconfigure(fn func(*Options)) error {
+	p.reconfigureLock.Lock()
+	defer p.reconfigureLock.Unlock()
+	opts := p.currentOptions()
+	fn(&opts)
+	if opts.ExpiryDuration < 0 {
+		return ErrInvalidPoolExpiry
+	} else if opts.ExpiryDuration == 0 {
+		opts.ExpiryDuration = DefaultCleanIntervalTime
+	}
+	if opts.Logger == nil {
+		opts.Logger = defaultLogger
+	}
+	p.hot.Store(&hotOptions{panicHandler: opts.PanicHandler, logger: opts.Logger, expiry: opts.ExpiryDuration})
+	return nil
+}
+
+// currentOptions returns a copy of the options of this pool with the changes made by Reconfigure.
+func (p *Pool) currentOptions() Options {
+	opts := *p.options
+	hot := p.hotOptions()
+	opts.PanicHandler, opts.Logger, opts.ExpiryDuration = hot.panicHandler, hot.logger, hot.expiry
+	return opts
+}
+
+func (p *Pool) hotOptions() *hotOptions {
+	return p.hot.Load().(*hotOptions)
+}
+
+// Tune changes the capacity of this pool, note that it is noneffective to the infinite or pre-allocation pool.
+func (p *Pool) Tune(size int) {
+	capacity := p.Cap()
+	if capacity == -1 || size <= 0 || size == capacity || p.options.PreAlloc {
+		return
+	}
+	atomic.StoreInt32(&p.capacity, int32(size))
+	if size > capacity {
+		if size-capacity == 1 {
+			p.cond.Signal()
+			return
+		}
+		p.cond.Broadcast()
+	}
+}
+
+// refreshCapacity tunes this pool to the capacity returned by Options.DynamicCapacity,
+// which is called at most once per dynamicCapacityTTL.
+func (p *Pool) refreshCapacity() {
+	capacity := p.options.DynamicCapacity
+	if capacity == nil {
+		return
+	}
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&p.capacityCheckedAt)
+	if now-last < int64(dynamicCapacityTTL) || !atomic.CompareAndSwapInt64(&p.capacityCheckedAt, last, now) {
+		return
+	}
+	p.Tune(capacity())
+}
+
+// reserveSpawn reserves the turn of a new worker to be spawned under SpawnRate, and returns how long
+// to wait for that turn. If wait is false, the turn is only reserved if it's due at once.
+func (p *Pool) reserveSpawn(wait bool) (time.Duration, bool) {
+	rate := p.options.SpawnRate
+	if rate <= 0 {
+		return 0, true
+	}
+	interval := int64(float64(time.Second) / rate)
+	for {
+		now := time.Now().UnixNano()
+		next := atomic.LoadInt64(&p.nextSpawnAt)
+		turn := next
+		if turn < now {
+			turn = now
+		}
+		if !wait && turn > now {
+			return 0, false
+		}
+		if atomic.CompareAndSwapInt64(&p.nextSpawnAt, next, turn+interval) {
+			return time.Duration(turn - now), true
+		}
+	}
+}
+
+// unreserveSpawn gives back the turn reserved by reserveSpawn for a worker which isn't spawned after all.
+func (p *Pool) unreserveSpawn() {
+	if rate := p.options.SpawnRate; rate > 0 {
+		atomic.AddInt64(&p.nextSpawnAt, -int64(float64(time.Second)/rate))
+	}
+}
+
+// BoostCapacity raises the capacity of this pool by extra for the duration d, then lowers it by extra again,
+// which suits a known traffic spike. The boosts compose, each of the overlapping boosts is restored
+// independently on its own timer. It's noneffective to the infinite or pre-allocation pool like Tune.
+func (p *Pool) BoostCapacity(extra int, d time.Duration) {
+	if extra <= 0 || p.Cap() == -1 || p.options.PreAlloc {
+		return
+	}
+	p.boostLock.Lock()
+	p.Tune(p.Cap() + extra)
+	p.boostLock.Unlock()
+	time.AfterFunc(d, func() {
+		p.addGoroutines(1)
+		defer p.addGoroutines(-1)
+		p.boostLock.Lock()
+		p.Tune(p.Cap() - extra)
+		p.boostLock.Unlock()
+	})
+}
+
+// RecomputeCapacity re-evaluates the capacity of a pool sized by WithCapacityPerCPU against
+// the current GOMAXPROCS, it's a no-op for the other pools.
+func (p *Pool) RecomputeCapacity() {
+	if k := p.options.CapacityPerCPU; k > 0 {
+		p.Tune(k * runtime.GOMAXPROCS(0))
+	}
+}
+
+// TryTune is like Tune, but it refuses to shrink the capacity below the number of busy workers
+// and returns ErrWouldDropWork instead, leaving the capacity unchanged. Growth always succeeds.
+func (p *Pool) TryTune(size int) error {
+	if size < p.Cap() {
+		p.lock.Lock()
+		busy := p.Running() - p.workers.len()
+		p.lock.Unlock()
+		if size < busy {
+			return ErrWouldDropWork
+		}
+	}
+	p.Tune(size)
+	return nil
+}
+
+// TuneAndWait is like Tune, but it also blocks until the number of running workers converges to
+// the new capacity or the timeout elapses, so that callers can be sure a shrink has taken effect.
+// The excess idle workers are stopped at once, while the excess busy workers exit after their tasks.
+func (p *Pool) TuneAndWait(size int, timeout time.Duration) error {
+	p.Tune(size)
+	if p.Cap() != size {
+		return nil
+	}
+
+	endTime := time.Now().Add(timeout)
+	for {
+		excess := p.Running() - p.Cap()
+		if excess <= 0 {
+			return nil
+		}
+		p.Shrink(excess)
+		if !time.Now().Before(endTime) {
+			return ErrTimeout
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Shrink stops up to n idle workers at once and returns how many of them were actually stopped, without
+// changing the capacity, which reclaims the memory of idle goroutines before they expire in a known quiet period.
+func (p *Pool) Shrink(n int) int {
+	var idleWorkers []worker
+	p.lock.Lock()
+	for len(idleWorkers) < n {
+		w := p.workers.detach()
+		if w == nil {
+			break
+		}
+		idleWorkers = append(idleWorkers, w)
+	}
+	p.lock.Unlock()
+
+	// Notify the workers to stop outside the p.lock like purgeStaleWorkers does.
+	for _, w := range idleWorkers {
+		w.finish()
+	}
+	return len(idleWorkers)
+}
+
+// IsClosed indicates whether the pool is closed.
+func (p *Pool) IsClosed() bool {
+	return atomic.LoadInt32(&p.state) == CLOSED
+}
+
+// Release closes this pool and releases the worker queue.
+func (p *Pool) Release() {
+	if !atomic.CompareAndSwapInt32(&p.state, OPENED, CLOSED) {
+		return
+	}
+	p.release()
+	p.runReleaseHooks()
+}
+
+// ReleaseDrainQueue closes this pool to the new submissions, but keeps the workers running the tasks of
+// the goroutines already blocked on Pool.Submit() until none is left, then it releases this pool and waits
+// for all workers to exit like ReleaseTimeout, unlike ShutdownNow which discards those tasks.
+// It returns ErrTimeout if that can't be done before timeout, and the pool is released anyway.
+//
+// Under AcceptDuringDrain, the new submissions keep being accepted as long as a worker is available at once,
+// and this pool isn't released until the running tasks are done as well as the queued ones.
+func (p *Pool) ReleaseDrainQueue(timeout time.Duration) error {
+	if p.IsClosed() || !atomic.CompareAndSwapInt32(&p.draining, 0, 1) {
+		return ErrPoolClosed
+	}
+	deadline := time.Now().Add(timeout)
+	for p.Waiting() > 0 || (p.options.AcceptDuringDrain && p.busyWorkers() > 0) {
+		if !time.Now().Before(deadline) {
+			p.Release()
+			return ErrTimeout
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return p.ReleaseTimeout(time.Until(deadline))
+}
+
+// Quiesce rejects the new submissions with ErrPoolQuiesced and blocks until at most target workers are
+// running tasks, including the tasks of the goroutines already blocked on Pool.Submit(), then it resumes
+// accepting the submissions, leaving this pool usable rather than released. It returns ErrTimeout if that
+// can't be done before timeout, and the submissions are resumed anyway, or ErrPoolClosed if this pool
+// is closed or already being drained or quiesced.
+func (p *Pool) Quiesce(target int, timeout time.Duration) error {
+	if p.IsClosed() || !atomic.CompareAndSwapInt32(&p.draining, 0, 2) {
+		return ErrPoolClosed
+	}
+	defer atomic.CompareAndSwapInt32(&p.draining, 2, 0)
+	deadline := time.Now().Add(timeout)
+	for p.Waiting() > 0 || p.busyWorkers() > target {
+		if !time.Now().Before(deadline) {
+			return ErrTimeout
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// DetectReentrantDeadlock reports whether this pool looks deadlocked by the re-entrant submissions, that is,
+// every worker is running a task which is itself blocked on submitting to a saturated pool, so that none of
+// them can ever be freed. It walks the stacks of all goroutines, which is meant for diagnosing a hanging
+// program rather than for being polled, and the workers blocked on submitting to another saturated pool
+// are counted as well, so it's only a hint.
+func (p *Pool) DetectReentrantDeadlock() bool {
+	capacity := p.Cap()
+	running := p.Running()
+	if capacity == -1 || running < capacity || p.Waiting() < running || p.busyWorkers() < running {
+		return false
+	}
+	return reentrantSubmitters() >= running
+}
+
+// reentrantSubmitters returns the number of the worker goroutines blocked on waiting for a worker.
+func reentrantSubmitters() (n int) {
+	buf := make([]byte, 64<<10)
+	for {
+		size := runtime.Stack(buf, true)
+		if size < len(buf) {
+			buf = buf[:size]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(stack, ".(*Pool).retrieveWorker(") && strings.Contains(stack, ".(*goWorker).run.func") {
+			n++
+		}
+	}
+	return
+}
+
+// busyWorkers returns the number of workers running tasks rather than idling in the worker queue.
+func (p *Pool) busyWorkers() int {
+	p.lock.Lock()
+	idle := p.workers.len()
+	p.lock.Unlock()
+	return p.Running() - idle
+}
+
+// ShutdownNow closes this pool like Release, and returns the tasks that were submitted but never started,
+// which are the tasks of the goroutines still blocked on Pool.Submit() or Pool.SubmitClass(),
+// including the ones waiting for their turns under SpawnRate, those calls return ErrPoolClosed.
+// The running tasks are left to finish.
+func (p *Pool) ShutdownNow() []func() {
+	p.lock.Lock()
+	if !atomic.CompareAndSwapInt32(&p.state, OPENED, CLOSED) {
+		p.lock.Unlock()
+		return nil
+	}
+	// Collect the pending tasks within the lock scope where the pool gets closed,
+	// so that none of them can be handed over to a worker afterward.
+	tasks := make([]func(), 0, p.pending.Len())
+	for e := p.pending.Front(); e != nil; e = e.Next() {
+		tasks = append(tasks, e.Value.(*pendingTask).task)
+	}
+	for e := p.spawning.Front(); e != nil; e = e.Next() {
+		tasks = append(tasks, e.Value.(*pendingTask).task)
+	}
+	p.lock.Unlock()
+	tasks = append(tasks, p.classes.reject()...)
+	p.release()
+	p.runReleaseHooks()
+	return tasks
+}
+
+// ShutdownNowPersist is like ShutdownNow, but each of the unstarted tasks is handed to sink as it was
+// submitted instead of being returned, e.g. to persist what identifies it so that it can be replayed on restart.
+// Every task is handed to sink even if it fails, and the first error from sink is returned.
+func (p *Pool) ShutdownNowPersist(sink func(task func()) error) (err error) {
+	for _, task := range p.ShutdownNow() {
+		if sinkErr := sink(task); sinkErr != nil && err == nil {
+			err = sinkErr
+		}
+	}
+	return
+}
+
+// Broadcast runs task once on each of the workers currently spawned and waits for all of them to finish,
+// which is meant for refreshing the worker-local state. An idle worker runs it at once, and a busy worker
+// runs it right after its current task, so that it never interleaves with the regular tasks.
+//
+// It returns ErrBroadcastFromTask if it's called from a task running on a worker of this pool, since that worker
+// would never get to run its share while it waits for all the shares to finish.
+func (p *Pool) Broadcast(task func()) error {
+	if p.IsClosed() {
+		return ErrPoolClosed
+	}
+	if onWorker() && p.ownsGoroutine(goroutineID()) {
+		return ErrBroadcastFromTask
+	}
+	var wg sync.WaitGroup
+	run := func() {
+		defer wg.Done()
+		task()
+	}
+	idle := make(map[worker]struct{})
+	p.lock.Lock()
+	for w := p.workers.detach(); w != nil; w = p.workers.detach() {
+		idle[w] = struct{}{}
+	}
+	p.live.Range(func(key, _ interface{}) bool {
+		w := key.(*goWorker)
+		if _, ok := idle[w]; !ok {
+			wg.Add(1)
+			w.broadcasts = append(w.broadcasts, run)
+		}
+		return true
+	})
+	p.lock.Unlock()
+	for w := range idle {
+		wg.Add(1)
+		w.inputFunc(run)
+	}
+	wg.Wait()
+	return nil
+}
+
+// ownsGoroutine reports whether the goroutine with the given ID is a worker of this pool.
+func (p *Pool) ownsGoroutine(gid uint64) (owned bool) {
+	p.live.Range(func(key, _ interface{}) bool {
+		owned = atomic.LoadUint64(&key.(*goWorker).clock.gid) == gid
+		return !owned
+	})
+	return
+}
+
+// onWorker reports whether the calling goroutine is a worker of any Pool, which is much cheaper
+// to tell than the ID of the goroutine.
+func onWorker() bool {
+	// The frame of the worker is the outermost one, so make sure that the whole stack is collected.
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(2, pcs)
+	for n == len(pcs) {
+		pcs = make([]uintptr, 2*len(pcs))
+		n = runtime.Callers(2, pcs)
+	}
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if strings.HasSuffix(frame.Function, ".(*goWorker).run.func1") {
+			return true
+		}
+		if !more {
+			return false
+		}
+	}
+}
+
+// DrainTo moves the tasks of the goroutines blocked on submitting to this pool over to dst, for retiring
+// this pool or rebalancing the load, it returns the number of tasks moved. Each of those goroutines
+// then submits its task to dst instead, and returns the result of that.
+func (p *Pool) DrainTo(dst *Pool) (moved int, err error) {
+	if dst == p {
+		return 0, ErrDrainToSelf
+	}
+	if p.IsClosed() || dst.IsClosed() {
+		return 0, ErrPoolClosed
+	}
+	p.lock.Lock()
+	for e := p.pending.Front(); e != nil; {
+		next := e.Next()
+		p.pending.Remove(e).(*pendingTask).dst = dst
+		moved++
+		e = next
+	}
+	p.lock.Unlock()
+	if moved > 0 {
+		p.cond.Broadcast()
+	}
+	return
+}
+
+// release stops the background goroutines and the idle workers of a pool that was just closed.
+func (p *Pool) release() {
+	if p.stopWatch != nil {
+		p.stopWatch()
+		p.stopWatch = nil
+	}
+	p.lock.Lock()
+	autoRelease := p.autoRelease
+	p.autoRelease = nil
+	p.lock.Unlock()
+	if autoRelease != nil {
+		autoRelease.Stop()
+	}
+
+	p.emit(PoolReleased)
+
+	p.scheduleLock.Lock()
+	for id, timer := range p.scheduled {
+		timer.Stop()
+		delete(p.scheduled, id)
+	}
+	p.scheduleLock.Unlock()
+	if p.stopPurge != nil {
+		p.stopPurge()
+		p.stopPurge = nil
+	}
+	p.stopTicktock()
+	p.stopTicktock = nil
+
+	p.classes.reject()
+
+	p.lock.Lock()
+	p.workers.reset()
+	p.dropYielded()
+	p.lock.Unlock()
+	// There might be some callers waiting in retrieveWorker(), so we need to wake them up to prevent
+	// those callers blocking infinitely.
+	p.cond.Broadcast()
+}
+
+// ReleaseTimeout is like Release but with a timeout, it waits all workers to exit before timing out.
+// If some tasks are still running by then, it returns ErrTimeout and abandons their workers,
+// which exit on their own once the tasks return, the pool is closed either way.
+func (p *Pool) ReleaseTimeout(timeout time.Duration) error {
+	return p.ReleaseTimeoutWithProgress(timeout, nil)
+}
+
+// ReleaseTimeoutWithProgress is like ReleaseTimeout, but while waiting it calls progress
+// with the number of workers still running whenever that number changes.
+func (p *Pool) ReleaseTimeoutWithProgress(timeout time.Duration, progress func(remaining int)) error {
+	if p.IsClosed() || (!p.options.DisablePurge && p.stopPurge == nil) || p.stopTicktock == nil {
+		return ErrPoolClosed
+	}
+	if !atomic.CompareAndSwapInt32(&p.state, OPENED, CLOSED) {
+		return ErrPoolClosed
+	}
+	p.release()
+	// The hooks are run once the workers have exited or the wait has timed out.
+	defer p.runReleaseHooks()
+
+	reported := -1
+	endTime := time.Now().Add(timeout)
+	for time.Now().Before(endTime) {
+		remaining := p.Running()
+		if progress != nil && remaining != reported {
+			reported = remaining
+			progress(remaining)
+		}
+		if remaining == 0 &&
+			(p.options.DisablePurge || atomic.LoadInt32(&p.purgeDone) == 1) &&
+			atomic.LoadInt32(&p.ticktockDone) == 1 {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return ErrTimeout
+}
+
+// Reboot reboots a closed pool.
+func (p *Pool) Reboot() {
+	if atomic.CompareAndSwapInt32(&p.state, CLOSED, OPENED) {
+		if p.options.ResetCountersOnReboot {
+			p.resetCounters()
+		}
+		atomic.StoreInt32(&p.draining, 0)
+		atomic.StoreInt32(&p.purgeDone, 0)
+		p.goPurge()
+		atomic.StoreInt32(&p.ticktockDone, 0)
+		p.goTicktock()
+	}
+}
+
+// resetCounters resets the lifetime counters, the peak of the running workers restarts from the current number.
+func (p *Pool) resetCounters() {
+	atomic.StoreUint64(&p.submitted, 0)
+	atomic.StoreUint64(&p.completed, 0)
+	atomic.StoreUint64(&p.rejected, 0)
+	atomic.StoreUint64(&p.breaches, 0)
+	atomic.StoreUint64(&p.execTime, 0)
+	if p.latency != nil {
+		p.latency.reset()
+	}
+	atomic.StoreInt32(&p.peakRunning, atomic.LoadInt32(&p.running))
+}
+
+// Events returns the channel of the transition events of this pool, which is only fed under WithEvents.
+// The events are best-effort, they're dropped instead of blocking the pool if the consumer is slow.
+func (p *Pool) Events() <-chan PoolEvent {
+	return p.events
+}
+
+// Clone creates a new Pool with the same capacity and options as this pool,
+// the new pool owns its workers and shares no mutable state with this one.
+func (p *Pool) Clone() (*Pool, error) {
+	opts := p.currentOptions()
+	return newPool(p.Cap(), p.manager, WithOptions(opts))
+}
+
+// ---------------------------------------------------------------------------
+
+func (p *Pool) addRunning(delta int) {
+	running := atomic.AddInt32(&p.running, int32(delta))
+	for peak := atomic.LoadInt32(&p.peakRunning); running > peak; peak = atomic.LoadInt32(&p.peakRunning) {
+		if atomic.CompareAndSwapInt32(&p.peakRunning, peak, running) {
+			break
+		}
+	}
+}
+
+func (p *Pool) taskSubmitted() {
+	atomic.AddUint64(&p.submitted, 1)
+	p.emit(TaskSubmitted)
+}
+
+func (p *Pool) taskCompleted() {
+	atomic.AddUint64(&p.completed, 1)
+}
+
+func (p *Pool) addGoroutines(delta int) {
+	atomic.AddInt32(&p.goroutines, int32(delta))
+}
+
+func (p *Pool) addWaiting(delta int) {
+	atomic.AddInt32(&p.waiting, int32(delta))
+}
+
+// admission is what has been reserved for a task by admit.
+type admission struct {
+	bytes    uint64
+	admitted bool
+}
+
+// admit applies the memory guard, the admitter and the middlewares to the task, it returns the task to dispatch
+// along with the admission reserved for it, which must be revoked if the task is not dispatched eventually.
+func (p *Pool) admit(ctx context.Context, task func()) (func(), admission, error) {
+	var a admission
+	switch atomic.LoadInt32(&p.draining) {
+	case 1:
+		if !p.options.AcceptDuringDrain {
+			return nil, a, ErrPoolClosed
+		}
+	case 2:
+		return nil, a, ErrPoolQuiesced
+	}
+	if cb := p.options.CircuitBreaker; cb != nil && !cb.Allow() {
+		return nil, a, ErrCircuitOpen
+	}
+	if limit := p.options.MemoryPressureLimit; limit > 0 {
+		if heap := atomic.LoadUint64(&p.heapAlloc); heap > limit {
+			return nil, a, &PoolMemoryPressureError{HeapAlloc: heap, Limit: limit}
+		}
+	}
+	if estimate := p.options.MemoryEstimator; estimate != nil {
+		a.bytes = estimate(task)
+		if !p.acquireMemory(a.bytes) {
+			return nil, a, ErrMemoryBudgetExceeded
+		}
+	}
+	if admitter := p.options.Admitter; admitter != nil {
+		if err := acquire(ctx, admitter); err != nil {
+			p.releaseMemory(a.bytes)
+			return nil, admission{}, err
+		}
+		a.admitted = true
+	}
+	task = p.wrap(task)
+	if a == (admission{}) {
+		return task, a, nil
+	}
+	return func() {
+		defer p.revoke(a)
+		task()
+	}, a, nil
+}
+
+// nonblocking is the context passed to the Admitter by the submissions which never block, it's always done.
+var nonblocking = func() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}()
+
+// acquire admits a task by the admitter, if ctx is already done, TryAdmitter.TryAcquire is used instead
+// of Acquire when the admitter implements it, and the task is rejected with ErrPoolOverload if it's not admitted.
+func acquire(ctx context.Context, admitter Admitter) error {
+	if tryer, ok := admitter.(TryAdmitter); ok && ctx.Err() != nil {
+		if !tryer.TryAcquire() {
+			return ErrPoolOverload
+		}
+		return nil
+	}
+	return admitter.Acquire(ctx)
+}
+
+// revoke gives back what has been reserved by admit.
+func (p *Pool) revoke(a admission) {
+	p.releaseMemory(a.bytes)
+	if a.admitted {
+		p.options.Admitter.Release()
+	}
+}
+
+// wrap wraps the task with the middlewares, the first middleware ends up the outermost,
+// and it captures the context of the submitter for WithContextPropagation, the innermost
+// wrapper hands the original task over to the DeadLetter if it panics.
+func (p *Pool) wrap(task func()) func() {
+	if sink := p.options.DeadLetter; sink != nil {
+		original := task
+		task = func() {
+			defer func() {
+				if r := recover(); r != nil {
+					sink(original, r)
+				}
+			}()
+			original()
+		}
+	}
+	mws := p.options.Middlewares
+	for i := len(mws) - 1; i >= 0; i-- {
+		task = mws[i](task)
+	}
+	if extract, inject := p.options.ContextExtractor, p.options.ContextInjector; extract != nil && inject != nil {
+		ctx, next := extract(), task
+		task = func() {
+			inject(ctx)
+			defer inject(nil)
+			next()
+		}
+	}
+	if p.events != nil {
+		next := task
+		task = func() {
+			defer p.emit(TaskCompleted)
+			next()
+		}
+	}
+	if p.options.CaptureCaller {
+		caller, next := captureCaller(), task
+		task = func() {
+			p.callers.Store(&caller, struct{}{})
+			defer p.callers.Delete(&caller)
+			next()
+		}
+	}
+	return task
+}
+
+// packagePrefix is the prefix of the names of the functions of this package.
+var packagePrefix = strings.TrimSuffix(runtime.FuncForPC(reflect.ValueOf((*Pool).Cap).Pointer()).Name(), "(*Pool).Cap")
+
+// captureCaller returns the first frame outside this package on the stack of the calling goroutine,
+// so that the helpers submitting on behalf of the caller, like PoolWaitGroup, are skipped as well.
+func captureCaller() runtime.Frame {
+	var pcs [32]uintptr
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs[:])])
+	for {
+		frame, more := frames.Next()
+		inPackage := strings.HasPrefix(frame.Function, packagePrefix) && !strings.HasSuffix(frame.File, "_test.go")
+		if !inPackage || !more {
+			return frame
+		}
+	}
+}
+
+// acquireMemory reserves bytes from the memory budget, it reports false if the budget would be exceeded.
+func (p *Pool) acquireMemory(bytes uint64) bool {
+	for {
+		inflight := atomic.LoadUint64(&p.inflightBytes)
+		if inflight+bytes < inflight || inflight+bytes > p.options.MemoryBudget {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(&p.inflightBytes, inflight, inflight+bytes) {
+			return true
+		}
+	}
+}
+
+// releaseMemory gives bytes back to the memory budget.
+func (p *Pool) releaseMemory(bytes uint64) {
+	if bytes > 0 {
+		atomic.AddUint64(&p.inflightBytes, ^(bytes - 1))
+	}
+}
+
+// notifySaturation signals the transition between the saturated and unsaturated states of this pool.
+func (p *Pool) notifySaturation() {
+	if atomic.LoadInt32(&p.watchSaturation) == 0 {
+		return
+	}
+
+	p.lock.Lock()
+	capacity := p.Cap()
+	full := capacity != -1 && p.Running() >= capacity && p.workers.isEmpty()
+	waiting := p.Waiting() > 0
+	p.lock.Unlock()
+
+	if full {
+		if atomic.CompareAndSwapInt32(&p.saturated, 0, 1) {
+			notify(p.saturationSignal)
+		}
+		if onFull := p.options.OnFull; onFull != nil && atomic.CompareAndSwapInt32(&p.onFullCalled, 0, 1) {
+			p.runCallback(onFull)
+		}
+		return
+	}
+	// A worker handed over to a waiting task doesn't end the saturation episode.
+	if waiting {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&p.saturated, 1, 0) {
+		notify(p.desaturationSignal)
+	}
+	atomic.StoreInt32(&p.onFullCalled, 0)
+}
+
+// OnRelease registers fn to be called once this pool is released, after the workers have exited
+// if it's released by ReleaseTimeout or ReleaseDrainQueue. The callbacks are called in the order
+// they're registered, and a panicking callback doesn't prevent the next ones from being called.
+// The callbacks registered after the release are called on the next release of a rebooted pool.
+func (p *Pool) OnRelease(fn func()) {
+	p.releaseHooksLock.Lock()
+	p.releaseHooks = append(p.releaseHooks, fn)
+	p.releaseHooksLock.Unlock()
+}
+
+// runReleaseHooks calls and unregisters the callbacks registered by OnRelease.
+func (p *Pool) runReleaseHooks() {
+	p.releaseHooksLock.Lock()
+	hooks := p.releaseHooks
+	p.releaseHooks = nil
+	p.releaseHooksLock.Unlock()
+	for _, fn := range hooks {
+		p.runCallback(fn)
+	}
+}
+
+// report feeds the outcome of a task to the CircuitBreaker.
+func (p *Pool) report(err error) {
+	if cb := p.options.CircuitBreaker; cb != nil {
+		cb.Report(err == nil)
+	}
+}
+
+// runInline runs the task on the calling goroutine under Synchronous, its panic is recovered
+// and handled like the panic of a task run by a worker.
+func (p *Pool) runInline(task func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if ph := p.hotOptions().panicHandler; ph != nil {
+				ph(r)
+			} else {
+				p.hotOptions().logger.Printf("task panics inline: %v\n%s\n", r, debug.Stack())
+			}
+		}
+	}()
+	task()
+}
+
+// runCallback calls fn and recovers from its panic.
+func (p *Pool) runCallback(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if ph := p.hotOptions().panicHandler; ph != nil {
+				ph(r)
+			} else {
+				p.hotOptions().logger.Printf("callback panics: %v\n%s\n", r, debug.Stack())
+			}
+		}
+	}()
+	fn()
+}
+
+// emit sends the event to the events channel without blocking.
+func (p *Pool) emit(e PoolEvent) {
+	if p.events != nil {
+		select {
+		case p.events <- e:
+		default:
+		}
+	}
+}
+
+// notify sends a signal to ch without blocking, it's a no-op if a signal is already buffered in ch.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// retrieveWorker returns an available worker to run the task, along with the time it spent blocking,
+// or the pool the task has been moved to by Pool.DrainTo() while blocking. The task is the one submitted
+// by the caller rather than any wrapper of it, so that it can be handed back by Pool.ShutdownNow() as is.
+func (p *Pool) retrieveWorker(task func()) (w worker, waited time.Duration, moved *Pool) {
+	spawnWorker := func() {
+		// A nonblocking submission doesn't wait for the turn of a new worker, it's rejected instead.
+		delay, ok := p.reserveSpawn(!p.options.Nonblocking)
+		if !ok {
+			return
+		}
+		if delay <= 0 {
+			w = p.workerCache.Get().(*goWorker)
+			w.run()
+			return
+		}
+		// Hold the capacity for the worker while it waits for its turn to be spawned,
+		// and let Pool.ShutdownNow() hand back the task in the meantime.
+		p.lock.Lock()
+		e := p.spawning.PushBack(&pendingTask{task: task})
+		p.lock.Unlock()
+		p.addRunning(1)
+		time.Sleep(delay)
+		p.addRunning(-1)
+		notify(p.stopped)
+		p.lock.Lock()
+		p.spawning.Remove(e)
+		if p.IsClosed() {
+			p.lock.Unlock()
+			p.unreserveSpawn()
+			return
+		}
+		// Spawn the worker within the lock scope, so that the pool can't get closed before it takes the task.
+		w = p.workerCache.Get().(*goWorker)
+		w.run()
+		p.lock.Unlock()
+	}
+
+	p.refreshCapacity()
+	p.lock.Lock()
+	// Under FIFOTasks, a new task mustn't take a worker ahead of the tasks that are already waiting.
+	queued := p.options.FIFOTasks && p.pending.Len() > 0
+	if !queued {
+		w = p.workers.detach()
+	}
+	if w != nil { // first try to fetch the worker from the queue
+		p.lock.Unlock()
+	} else if capacity := p.Cap(); !queued && (capacity == -1 || capacity > p.Running()) {
+		// if the worker queue is empty and we don't run out of the pool capacity,
+		// then just spawn a new worker goroutine.
+		p.lock.Unlock()
+		spawnWorker()
+	} else { // otherwise, we'll have to keep them blocked and wait for at least one worker to be put back into pool.
+		if p.options.Nonblocking {
+			p.lock.Unlock()
+			return
+		}
+		start := time.Now()
+		defer func() {
+			waited = time.Since(start)
+		}()
+		front := false
+	retry:
+		if p.options.MaxBlockingTasks != 0 && p.Waiting() >= p.options.MaxBlockingTasks {
+			p.lock.Unlock()
+			return
+		}
+
+		pt := &pendingTask{task: task}
+		var e *list.Element
+		if front {
+			e = p.pending.PushFront(pt)
+		} else {
+			e = p.pending.PushBack(pt)
+		}
+		p.addWaiting(1)
+		p.cond.Wait() // block and wait for an available worker
+		for p.options.FIFOTasks && pt.dst == nil && !p.IsClosed() && p.pending.Front() != e {
+			// The available worker belongs to the task at the front, pass the wakeup on until it reaches that task.
+			p.cond.Signal()
+			p.cond.Wait()
+		}
+		p.addWaiting(-1)
+		p.pending.Remove(e)
+
+		if pt.dst != nil {
+			p.lock.Unlock()
+			moved = pt.dst
+			return
+		}
+
+		if p.IsClosed() {
+			p.lock.Unlock()
+			return
+		}
+
+		if w = p.workers.detach(); w == nil {
+			if p.Free() > 0 {
+				p.lock.Unlock()
+				spawnWorker()
+				return
+			}
+			// Keep the place at the front of the queue under FIFOTasks.
+			front = p.options.FIFOTasks
+			goto retry
+		}
+		p.lock.Unlock()
+	}
+	return
+}
+
+// tryRetrieveWorker is like retrieveWorker but never blocks, it returns nil if there is no available worker at once,
+// or if fewer than reserve workers would be left available after taking one.
+func (p *Pool) tryRetrieveWorker(reserve int) (w worker) {
+	p.refreshCapacity()
+	p.lock.Lock()
+	if capacity := p.Cap(); reserve > 0 && capacity != -1 && capacity-(p.Running()-p.workers.len())-1 < reserve {
+		p.lock.Unlock()
+		return
+	}
+	if w = p.workers.detach(); w == nil {
+		if capacity := p.Cap(); capacity == -1 || capacity > p.Running() {
+			if _, ok := p.reserveSpawn(false); ok {
+				// Spawn the worker within the lock scope, so that concurrent callers can't exceed the capacity.
+				w = p.workerCache.Get().(*goWorker)
+				w.run()
+			}
+		}
+	}
+	p.lock.Unlock()
+	return
+}
+
+// revertWorker puts a worker back into free pool, recycling the goroutines.
+func (p *Pool) revertWorker(worker *goWorker) bool {
+	if capacity := p.Cap(); (capacity > 0 && p.Running() > capacity) || p.IsClosed() {
+		p.cond.Broadcast()
+		return false
+	}
+
+	worker.lastUsed = p.nowTime()
+
+	p.lock.Lock()
+	// Run the broadcasts to this worker before putting it back within the lock scope,
+	// so that none of them can be left behind once it's in the queue.
+	for len(worker.broadcasts) > 0 {
+		broadcasts := worker.broadcasts
+		worker.broadcasts = nil
+		p.lock.Unlock()
+		for _, f := range broadcasts {
+			f()
+		}
+		p.lock.Lock()
+	}
+	// To avoid memory leaks, add a double check in the lock scope.
+	// Issue: https://github.com/panjf2000/ants/issues/113
+	if p.IsClosed() {
+		p.lock.Unlock()
+		return false
+	}
+	// Stop the worker instead of parking it if there are enough idle workers already.
+	if maxIdle := p.options.MaxIdleWorkers; maxIdle > 0 && p.workers.len() >= maxIdle {
 		p.lock.Unlock()
 		return false
 	}
@@ -403,6 +2195,8 @@ func (p *Pool) revertWorker(worker *goWorker) bool {
 	// Notify the invoker stuck in 'retrieveWorker()' of there is an available worker in the worker queue.
 	p.cond.Signal()
 	p.lock.Unlock()
+	notify(p.availability)
+	p.notifySaturation()
 
 	return true
 }
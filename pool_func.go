@@ -24,6 +24,8 @@ package ants
 
 import (
 	"context"
+	"runtime"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -34,12 +36,22 @@ import (
 // PoolWithFunc accepts the tasks from client,
 // it limits the total of goroutines to a given number by recycling goroutines.
 type PoolWithFunc struct {
+	// rejected is the number of invocations refused with ErrPoolOverload, it's placed first along with execTime
+	// to guarantee the 64-bit alignment required by atomic operations on 32-bit platforms.
+	rejected uint64
+
+	// execTime is the sum of the execution time of the completed tasks in nanoseconds.
+	execTime uint64
+
 	// capacity of the pool.
 	capacity int32
 
 	// running is the number of the currently running goroutines.
 	running int32
 
+	// goroutines is the number of all goroutines started by this pool that haven't exited yet.
+	goroutines int32
+
 	// lock for protecting the worker queue.
 	lock sync.Locker
 
@@ -69,11 +81,21 @@ type PoolWithFunc struct {
 
 	now atomic.Value
 
+	// userData stores the data attached to the pool by the user.
+	userData atomic.Value
+
+	// clocks is the set of the task clocks of live workers, it's only maintained under StuckTaskDump.
+	clocks sync.Map
+
+	// latency is the histogram of the execution time of tasks, it's nil unless LatencyTracking is set.
+	latency *latencyHistogram
+
 	options *Options
 }
 
 // purgeStaleWorkers clears stale workers periodically, it runs in an individual goroutine, as a scavenger.
 func (p *PoolWithFunc) purgeStaleWorkers(ctx context.Context) {
+	defer p.addGoroutines(-1)
 	ticker := time.NewTicker(p.options.ExpiryDuration)
 	defer func() {
 		ticker.Stop()
@@ -115,8 +137,11 @@ func (p *PoolWithFunc) purgeStaleWorkers(ctx context.Context) {
 	}
 }
 
-// ticktock is a goroutine that updates the current time in the pool regularly.
+// ticktock is a goroutine that updates the current time in the pool regularly,
+// it also checks whether the pool stays saturated for WithSaturationAlert and dumps the stuck tasks for WithStuckTaskDump.
 func (p *PoolWithFunc) ticktock(ctx context.Context) {
+	defer p.addGoroutines(-1)
+	var alert saturationAlert
 	ticker := time.NewTicker(nowTimeUpdateInterval)
 	defer func() {
 		ticker.Stop()
@@ -134,7 +159,16 @@ func (p *PoolWithFunc) ticktock(ctx context.Context) {
 			break
 		}
 
-		p.now.Store(time.Now())
+		now := time.Now()
+		p.now.Store(now)
+
+		if p.options.SaturationAlert != nil {
+			alert.observe(now, p.Free() == 0 && p.Waiting() > 0, p.options)
+		}
+
+		if p.options.StuckTaskDump > 0 {
+			dumpStuckTasks(&p.clocks, now, p.options.StuckTaskDump, p.options.Logger)
+		}
 	}
 }
 
@@ -146,6 +180,7 @@ func (p *PoolWithFunc) goPurge() {
 	// Start a goroutine to clean up expired workers periodically.
 	var ctx context.Context
 	ctx, p.stopPurge = context.WithCancel(context.Background())
+	p.addGoroutines(1)
 	go p.purgeStaleWorkers(ctx)
 }
 
@@ -153,6 +188,7 @@ func (p *PoolWithFunc) goTicktock() {
 	p.now.Store(time.Now())
 	var ctx context.Context
 	ctx, p.stopTicktock = context.WithCancel(context.Background())
+	p.addGoroutines(1)
 	go p.ticktock(ctx)
 }
 
@@ -162,6 +198,11 @@ func (p *PoolWithFunc) nowTime() time.Time {
 
 // NewPoolWithFunc generates an instance of ants pool with a specific function.
 func NewPoolWithFunc(size int, pf func(interface{}), options ...Option) (*PoolWithFunc, error) {
+	opts := loadOptions(options...)
+
+	if size <= 0 && opts.CapacityPerCPU > 0 {
+		size = opts.CapacityPerCPU * runtime.GOMAXPROCS(0)
+	}
 	if size <= 0 {
 		size = -1
 	}
@@ -170,8 +211,6 @@ func NewPoolWithFunc(size int, pf func(interface{}), options ...Option) (*PoolWi
 		return nil, ErrLackPoolFunc
 	}
 
-	opts := loadOptions(options...)
-
 	if !opts.DisablePurge {
 		if expiry := opts.ExpiryDuration; expiry < 0 {
 			return nil, ErrInvalidPoolExpiry
@@ -202,11 +241,15 @@ func NewPoolWithFunc(size int, pf func(interface{}), options ...Option) (*PoolWi
 		}
 		p.workers = newWorkerQueue(queueTypeLoopQueue, size)
 	} else {
-		p.workers = newWorkerQueue(queueTypeStack, 0)
+		p.workers = newWorkerQueue(queueTypeStack, p.options.InitialWorkerCap)
 	}
 
 	p.cond = sync.NewCond(p.lock)
 
+	if p.options.LatencyTracking {
+		p.latency = new(latencyHistogram)
+	}
+
 	p.goPurge()
 	p.goTicktock()
 
@@ -225,25 +268,79 @@ func (p *PoolWithFunc) Invoke(args interface{}) error {
 	if p.IsClosed() {
 		return ErrPoolClosed
 	}
+	if p.options.Synchronous {
+		p.addRunning(1)
+		defer p.addRunning(-1)
+		p.invokeInline(args)
+		return nil
+	}
 	if w := p.retrieveWorker(); w != nil {
 		w.inputParam(args)
 		return nil
 	}
+	atomic.AddUint64(&p.rejected, 1)
 	return ErrPoolOverload
 }
 
+// invokeInline runs the pool function on the calling goroutine under Synchronous, its panic is recovered
+// and handled like the panic of a task run by a worker.
+func (p *PoolWithFunc) invokeInline(args interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			if ph := p.options.PanicHandler; ph != nil {
+				ph(r)
+			} else {
+				p.options.Logger.Printf("task panics inline: %v\n%s\n", r, debug.Stack())
+			}
+		}
+	}()
+	p.poolFunc(args)
+}
+
 // Running returns the number of workers currently running.
 func (p *PoolWithFunc) Running() int {
 	return int(atomic.LoadInt32(&p.running))
 }
 
 // Free returns the number of available goroutines to work, -1 indicates this pool is unlimited.
+// It's taken from Snapshot, so it's 0 rather than negative while the pool shrinks below its running workers.
 func (p *PoolWithFunc) Free() int {
-	c := p.Cap()
-	if c < 0 {
-		return -1
+	_, _, free := p.Snapshot()
+	return free
+}
+
+// Snapshot returns the capacity, the number of running workers and the number of available goroutines
+// of this pool as a consistent triple even if the pool is being tuned concurrently, free is never negative
+// and -1 indicates this pool is unlimited.
+func (p *PoolWithFunc) Snapshot() (capacity, running, free int) {
+	capacity, running = p.Cap(), p.Running()
+	for {
+		// Retry until neither value has changed in the meantime, so that the pair is taken at one point
+		// even while the pool is being tuned or its workers come and go.
+		c, r := p.Cap(), p.Running()
+		if c == capacity && r == running {
+			break
+		}
+		capacity, running = c, r
+	}
+	if capacity < 0 {
+		return capacity, running, -1
 	}
-	return c - p.Running()
+	if free = capacity - running; free < 0 {
+		free = 0
+	}
+	return
+}
+
+// TotalRejected returns the number of invocations refused with ErrPoolOverload so far.
+func (p *PoolWithFunc) TotalRejected() uint64 {
+	return atomic.LoadUint64(&p.rejected)
+}
+
+// GoroutineCount returns the number of goroutines this pool currently owns, that is, the workers and
+// the goroutines purging the stale workers and updating the clock.
+func (p *PoolWithFunc) GoroutineCount() int {
+	return int(atomic.LoadInt32(&p.goroutines))
 }
 
 // Waiting returns the number of tasks which are waiting be executed.
@@ -251,6 +348,34 @@ func (p *PoolWithFunc) Waiting() int {
 	return int(atomic.LoadInt32(&p.waiting))
 }
 
+// LatencyHistogram returns the number of completed tasks per execution time bucket, keyed by
+// the inclusive upper bound of each bucket, it returns nil if LatencyTracking is not set.
+func (p *PoolWithFunc) LatencyHistogram() map[time.Duration]uint64 {
+	if p.latency == nil {
+		return nil
+	}
+	return p.latency.snapshot()
+}
+
+// TotalExecTime returns the sum of the execution time of the completed tasks, it returns 0
+// unless ExecTimeTracking or LatencyTracking is set.
+func (p *PoolWithFunc) TotalExecTime() time.Duration {
+	return time.Duration(atomic.LoadUint64(&p.execTime))
+}
+
+// SetUserData attaches arbitrary data to this pool, such as a tag or a config, which can be retrieved by UserData.
+func (p *PoolWithFunc) SetUserData(data interface{}) {
+	p.userData.Store(userData{data})
+}
+
+// UserData returns the data attached to this pool by SetUserData, or nil if there is none.
+func (p *PoolWithFunc) UserData() interface{} {
+	if data, ok := p.userData.Load().(userData); ok {
+		return data.value
+	}
+	return nil
+}
+
 // Cap returns the capacity of this pool.
 func (p *PoolWithFunc) Cap() int {
 	return int(atomic.LoadInt32(&p.capacity))
@@ -272,6 +397,73 @@ func (p *PoolWithFunc) Tune(size int) {
 	}
 }
 
+// RecomputeCapacity re-evaluates the capacity of a pool sized by WithCapacityPerCPU against
+// the current GOMAXPROCS, it's a no-op for the other pools.
+func (p *PoolWithFunc) RecomputeCapacity() {
+	if k := p.options.CapacityPerCPU; k > 0 {
+		p.Tune(k * runtime.GOMAXPROCS(0))
+	}
+}
+
+// TryTune is like Tune, but it refuses to shrink the capacity below the number of busy workers
+// and returns ErrWouldDropWork instead, leaving the capacity unchanged. Growth always succeeds.
+func (p *PoolWithFunc) TryTune(size int) error {
+	if size < p.Cap() {
+		p.lock.Lock()
+		busy := p.Running() - p.workers.len()
+		p.lock.Unlock()
+		if size < busy {
+			return ErrWouldDropWork
+		}
+	}
+	p.Tune(size)
+	return nil
+}
+
+// TuneAndWait is like Tune, but it also blocks until the number of running workers converges to
+// the new capacity or the timeout elapses, so that callers can be sure a shrink has taken effect.
+// The excess idle workers are stopped at once, while the excess busy workers exit after their tasks.
+func (p *PoolWithFunc) TuneAndWait(size int, timeout time.Duration) error {
+	p.Tune(size)
+	if p.Cap() != size {
+		return nil
+	}
+
+	endTime := time.Now().Add(timeout)
+	for {
+		excess := p.Running() - p.Cap()
+		if excess <= 0 {
+			return nil
+		}
+		p.Shrink(excess)
+		if !time.Now().Before(endTime) {
+			return ErrTimeout
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Shrink stops up to n idle workers at once and returns how many of them were actually stopped, without
+// changing the capacity, which reclaims the memory of idle goroutines before they expire in a known quiet period.
+func (p *PoolWithFunc) Shrink(n int) int {
+	var idleWorkers []worker
+	p.lock.Lock()
+	for len(idleWorkers) < n {
+		w := p.workers.detach()
+		if w == nil {
+			break
+		}
+		idleWorkers = append(idleWorkers, w)
+	}
+	p.lock.Unlock()
+
+	// Notify the workers to stop outside the p.lock like purgeStaleWorkers does.
+	for _, w := range idleWorkers {
+		w.finish()
+	}
+	return len(idleWorkers)
+}
+
 // IsClosed indicates whether the pool is closed.
 func (p *PoolWithFunc) IsClosed() bool {
 	return atomic.LoadInt32(&p.state) == CLOSED
@@ -299,15 +491,29 @@ func (p *PoolWithFunc) Release() {
 }
 
 // ReleaseTimeout is like Release but with a timeout, it waits all workers to exit before timing out.
+// If some tasks are still running by then, it returns ErrTimeout and abandons their workers,
+// which exit on their own once the tasks return, the pool is closed either way.
 func (p *PoolWithFunc) ReleaseTimeout(timeout time.Duration) error {
+	return p.ReleaseTimeoutWithProgress(timeout, nil)
+}
+
+// ReleaseTimeoutWithProgress is like ReleaseTimeout, but while waiting it calls progress
+// with the number of workers still running whenever that number changes.
+func (p *PoolWithFunc) ReleaseTimeoutWithProgress(timeout time.Duration, progress func(remaining int)) error {
 	if p.IsClosed() || (!p.options.DisablePurge && p.stopPurge == nil) || p.stopTicktock == nil {
 		return ErrPoolClosed
 	}
 	p.Release()
 
+	reported := -1
 	endTime := time.Now().Add(timeout)
 	for time.Now().Before(endTime) {
-		if p.Running() == 0 &&
+		remaining := p.Running()
+		if progress != nil && remaining != reported {
+			reported = remaining
+			progress(remaining)
+		}
+		if remaining == 0 &&
 			(p.options.DisablePurge || atomic.LoadInt32(&p.purgeDone) == 1) &&
 			atomic.LoadInt32(&p.ticktockDone) == 1 {
 			return nil
@@ -327,12 +533,23 @@ func (p *PoolWithFunc) Reboot() {
 	}
 }
 
+// Clone creates a new PoolWithFunc with the same capacity and options as this pool,
+// the new pool owns its workers and shares no mutable state with this one.
+func (p *PoolWithFunc) Clone() (*PoolWithFunc, error) {
+	opts := *p.options
+	return NewPoolWithFunc(p.Cap(), p.poolFunc, WithOptions(opts))
+}
+
 //---------------------------------------------------------------------------
 
 func (p *PoolWithFunc) addRunning(delta int) {
 	atomic.AddInt32(&p.running, int32(delta))
 }
 
+func (p *PoolWithFunc) addGoroutines(delta int) {
+	atomic.AddInt32(&p.goroutines, int32(delta))
+}
+
 func (p *PoolWithFunc) addWaiting(delta int) {
 	atomic.AddInt32(&p.waiting, int32(delta))
 }
@@ -402,6 +619,11 @@ func (p *PoolWithFunc) revertWorker(worker *goWorkerWithFunc) bool {
 		p.lock.Unlock()
 		return false
 	}
+	// Stop the worker instead of parking it if there are enough idle workers already.
+	if maxIdle := p.options.MaxIdleWorkers; maxIdle > 0 && p.workers.len() >= maxIdle {
+		p.lock.Unlock()
+		return false
+	}
 	if err := p.workers.insert(worker); err != nil {
 		p.lock.Unlock()
 		return false
@@ -0,0 +1,65 @@
+package ants
+
+import "sync"
+
+// Session pins a sequence of tasks to one worker of a Pool, the tasks run sequentially on the same goroutine
+// in the order they are passed to Session.Run, which suits the tasks bound to some goroutine-affine resource.
+// The worker is held by the session and counts against the capacity of the pool until Session.Close is called.
+type Session struct {
+	lock   sync.RWMutex
+	closed bool
+	tasks  chan func()
+	done   chan struct{}
+}
+
+// NewSession reserves a worker of this pool for a new session, it gets blocked like Pool.Submit
+// when there is no available worker. It returns ErrSynchronousSession under Synchronous.
+func (p *Pool) NewSession() (*Session, error) {
+	if p.options.Synchronous {
+		// The loop of the session would run inline and never return.
+		return nil, ErrSynchronousSession
+	}
+	s := &Session{
+		tasks: make(chan func()),
+		done:  make(chan struct{}),
+	}
+	if err := p.Submit(s.loop); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Session) loop() {
+	defer close(s.done)
+	for task := range s.tasks {
+		task()
+	}
+}
+
+// Run hands the task over to the worker of this session, it gets blocked until the worker has finished
+// the previous task. ErrSessionClosed is returned if the session has been closed, or its worker has exited
+// from a panic of the previous task.
+func (s *Session) Run(task func()) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return ErrSessionClosed
+	}
+	select {
+	case s.tasks <- task:
+		return nil
+	case <-s.done:
+		return ErrSessionClosed
+	}
+}
+
+// Close waits for the worker of this session to finish the tasks and puts it back into the pool.
+func (s *Session) Close() {
+	s.lock.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.tasks)
+	}
+	s.lock.Unlock()
+	<-s.done
+}
@@ -0,0 +1,74 @@
+package ants
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// SharedWorkers multiplexes several named queues onto the workers of one Pool, so that many subsystems
+// can share the goroutines instead of owning small pools of their own. When the workers are saturated,
+// they're distributed across the queues in proportion to the weights, as Pool.SubmitClass does.
+type SharedWorkers struct {
+	pool   *Pool
+	lock   sync.Mutex
+	queues map[string]*SharedQueue
+}
+
+// SharedQueue is a named queue of SharedWorkers, which keeps the stats of its own tasks.
+type SharedQueue struct {
+	name    string
+	pool    *Pool
+	running int32
+}
+
+// NewSharedWorkers creates the workers to be shared, size and options are as for NewPool,
+// and weights are the weights of the queues by their names, a queue without a weight has the weight of 1.
+func NewSharedWorkers(size int, weights map[string]int, options ...Option) (*SharedWorkers, error) {
+	p, err := NewPool(size, append(options, WithClassWeights(weights))...)
+	if err != nil {
+		return nil, err
+	}
+	return &SharedWorkers{pool: p, queues: make(map[string]*SharedQueue)}, nil
+}
+
+// Queue returns the queue of the name, it's created on the first call.
+func (s *SharedWorkers) Queue(name string) *SharedQueue {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	q, ok := s.queues[name]
+	if !ok {
+		q = &SharedQueue{name: name, pool: s.pool}
+		s.queues[name] = q
+	}
+	return q
+}
+
+// Running returns the number of the workers currently spawned for all queues.
+func (s *SharedWorkers) Running() int {
+	return s.pool.Running()
+}
+
+// Release closes the workers, the tasks of all queues are rejected with ErrPoolClosed afterwards.
+func (s *SharedWorkers) Release() {
+	s.pool.Release()
+}
+
+// Submit submits a task to the shared workers through this queue, it gets blocked like Pool.SubmitClass
+// when the workers are saturated, or rejected with ErrPoolOverload under Nonblocking or beyond MaxBlockingTasks.
+func (q *SharedQueue) Submit(task func()) error {
+	return q.pool.SubmitClass(q.name, func() {
+		atomic.AddInt32(&q.running, 1)
+		defer atomic.AddInt32(&q.running, -1)
+		task()
+	})
+}
+
+// Running returns the number of the tasks of this queue currently running.
+func (q *SharedQueue) Running() int {
+	return int(atomic.LoadInt32(&q.running))
+}
+
+// Waiting returns the number of the tasks of this queue waiting for a worker.
+func (q *SharedQueue) Waiting() int {
+	return q.pool.classes.waiting(q.name)
+}
@@ -0,0 +1,52 @@
+package ants
+
+import "encoding/binary"
+
+// StatsSize is the number of bytes appended by AppendStats.
+const StatsSize = 1 + 4*3 + 8
+
+// statsVersion is the first byte of the encoded stats, it changes whenever the layout does.
+const statsVersion = 1
+
+// Stats is the state of a pool as encoded by AppendStats.
+type Stats struct {
+	Capacity int
+	Running  int
+	Waiting  int
+	Rejected uint64
+}
+
+// AppendStats appends the compact fixed-layout encoding of the current stats of this pool to dst and
+// returns the extended buffer, so that a high-frequency poller can reuse the buffer without allocations.
+func (p *Pool) AppendStats(dst []byte) []byte {
+	return appendStats(dst, Stats{p.Cap(), p.Running(), p.Waiting(), p.TotalRejected()})
+}
+
+// AppendStats appends the compact fixed-layout encoding of the current stats of this pool to dst and
+// returns the extended buffer, so that a high-frequency poller can reuse the buffer without allocations.
+func (p *PoolWithFunc) AppendStats(dst []byte) []byte {
+	return appendStats(dst, Stats{p.Cap(), p.Running(), p.Waiting(), p.TotalRejected()})
+}
+
+// DecodeStats decodes the stats encoded by AppendStats from the front of b.
+func DecodeStats(b []byte) (Stats, error) {
+	if len(b) < StatsSize || b[0] != statsVersion {
+		return Stats{}, ErrInvalidStats
+	}
+	return Stats{
+		Capacity: int(int32(binary.BigEndian.Uint32(b[1:]))),
+		Running:  int(int32(binary.BigEndian.Uint32(b[5:]))),
+		Waiting:  int(int32(binary.BigEndian.Uint32(b[9:]))),
+		Rejected: binary.BigEndian.Uint64(b[13:]),
+	}, nil
+}
+
+func appendStats(dst []byte, s Stats) []byte {
+	var buf [StatsSize]byte
+	buf[0] = statsVersion
+	binary.BigEndian.PutUint32(buf[1:], uint32(s.Capacity))
+	binary.BigEndian.PutUint32(buf[5:], uint32(s.Running))
+	binary.BigEndian.PutUint32(buf[9:], uint32(s.Waiting))
+	binary.BigEndian.PutUint64(buf[13:], s.Rejected)
+	return append(dst, buf[:]...)
+}
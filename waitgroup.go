@@ -0,0 +1,84 @@
+package ants
+
+import (
+	"context"
+	"sync"
+)
+
+// PoolWaitGroup submits tasks to a Pool and waits for them to complete, like sync.WaitGroup along with
+// the goroutines it waits for, but the concurrency is bounded by the pool. Unlike waiting for the pool,
+// it only tracks the tasks added through this group.
+type PoolWaitGroup struct {
+	pool *Pool
+	wg   sync.WaitGroup
+}
+
+// NewWaitGroup returns a PoolWaitGroup submitting the tasks to this pool.
+func (p *Pool) NewWaitGroup() *PoolWaitGroup {
+	return &PoolWaitGroup{pool: p}
+}
+
+// Add submits the task to the pool like Pool.Submit and tracks it until it completes, even from a panic.
+// The task isn't tracked if it can't be submitted, and the error of submitting it is returned.
+func (g *PoolWaitGroup) Add(task func()) error {
+	g.wg.Add(1)
+	err := g.pool.Submit(func() {
+		defer g.wg.Done()
+		task()
+	})
+	if err != nil {
+		g.wg.Done()
+	}
+	return err
+}
+
+// Wait blocks until all tasks added to this group have completed.
+func (g *PoolWaitGroup) Wait() {
+	g.wg.Wait()
+}
+
+// CancelGroup is like PoolWaitGroup, but its tasks share a context which is cancelled once a task returns
+// an error, like errgroup.WithContext, so that the remaining tasks can give up early.
+type CancelGroup struct {
+	pool   *Pool
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	once sync.Once
+	err  error
+}
+
+// NewCancelGroup returns a CancelGroup submitting the tasks to this pool, the context shared by its tasks
+// is derived from ctx.
+func (p *Pool) NewCancelGroup(ctx context.Context) *CancelGroup {
+	ctx, cancel := context.WithCancel(ctx)
+	return &CancelGroup{pool: p, ctx: ctx, cancel: cancel}
+}
+
+// Go submits the task to the pool like PoolWaitGroup.Add, the task is passed the shared context,
+// and the first error it returns among the tasks of this group cancels that context.
+func (g *CancelGroup) Go(task func(ctx context.Context) error) error {
+	g.wg.Add(1)
+	err := g.pool.Submit(func() {
+		defer g.wg.Done()
+		if err := task(g.ctx); err != nil {
+			g.once.Do(func() {
+				g.err = err
+				g.cancel()
+			})
+		}
+	})
+	if err != nil {
+		g.wg.Done()
+	}
+	return err
+}
+
+// Wait blocks until all tasks added to this group have completed, then it cancels the shared context
+// and returns the first error returned by the tasks.
+func (g *CancelGroup) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
@@ -0,0 +1,82 @@
+package ants
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// taskClock records the goroutine of a worker and when its current task started, which is only maintained under StuckTaskDump.
+type taskClock struct {
+	// startedAt is the UnixNano time when the current task started, 0 means that the worker is idle,
+	// it's placed first to guarantee the 64-bit alignment required by atomic operations on 32-bit platforms.
+	startedAt int64
+
+	// gid is the ID of the worker goroutine.
+	gid uint64
+
+	// dumped is the startedAt of the last task whose stack has been dumped, it's only accessed by ticktock.
+	dumped int64
+}
+
+// register records the calling goroutine as the worker of this clock.
+func (c *taskClock) register() {
+	atomic.StoreUint64(&c.gid, goroutineID())
+}
+
+func (c *taskClock) start() {
+	atomic.StoreInt64(&c.startedAt, time.Now().UnixNano())
+}
+
+func (c *taskClock) stop() {
+	atomic.StoreInt64(&c.startedAt, 0)
+}
+
+// dumpStuckTasks logs the stack of every worker in clocks whose current task has been running
+// for longer than threshold, the stack is dumped only once per task.
+func dumpStuckTasks(clocks *sync.Map, now time.Time, threshold time.Duration, logger Logger) {
+	clocks.Range(func(key, _ interface{}) bool {
+		c := key.(*taskClock)
+		started := atomic.LoadInt64(&c.startedAt)
+		if started == 0 || started == c.dumped {
+			return true
+		}
+		if elapsed := now.Sub(time.Unix(0, started)); elapsed >= threshold {
+			c.dumped = started
+			logger.Printf("task has been running for %v on worker:\n%s\n", elapsed, goroutineStack(atomic.LoadUint64(&c.gid)))
+		}
+		return true
+	})
+}
+
+// goroutineID parses the ID of the calling goroutine from its stack trace, which begins with "goroutine <id> [".
+func goroutineID() uint64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	id, _ := strconv.ParseUint(string(bytes.Fields(buf[:n])[1]), 10, 64)
+	return id
+}
+
+// goroutineStack returns the stack trace of the goroutine with the given ID,
+// it's a best-effort full dump filtered down to that goroutine.
+func goroutineStack(id uint64) []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	header := []byte("goroutine " + strconv.FormatUint(id, 10) + " [")
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, header) {
+			return stack
+		}
+	}
+	return buf
+}
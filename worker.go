@@ -24,6 +24,7 @@ package ants
 
 import (
 	"runtime/debug"
+	"sync/atomic"
 	"time"
 )
 
@@ -31,6 +32,15 @@ import (
 // it starts a goroutine that accepts tasks and
 // performs function calls.
 type goWorker struct {
+	// clock records the goroutine of this worker and when its current task started for StuckTaskDump, it's placed first
+	// to guarantee the 64-bit alignment required by atomic operations on 32-bit platforms.
+	clock taskClock
+
+	// id, spawnedAt and served are reported by pool.ForEachWorker(), they're placed along with clock for the alignment.
+	id        uint64
+	spawnedAt int64
+	served    uint64
+
 	// pool who owns this worker.
 	pool *Pool
 
@@ -39,32 +49,92 @@ type goWorker struct {
 
 	// lastUsed will be updated when putting a worker back into queue.
 	lastUsed time.Time
+
+	// panics is the number of panics this worker has recovered from under PanicQuarantine.
+	panics int
+
+	// broadcasts holds the tasks of pool.Broadcast() to be run by this busy worker, protected by pool.lock.
+	broadcasts []func()
+
+	// busy indicates whether this worker is running a task.
+	busy int32
+
+	// tag is the tag of the task submitted by pool.SubmitTagged() this worker is running, protected by pool.lock.
+	tag string
+
+	// probe indicates whether the next task is the probe of pool.validate(), which is set before
+	// the probe is handed over and is kept out of the statistics of the pool.
+	probe bool
+
+	// resumed indicates whether the next task is the rest of a task yielded by pool.SubmitYielding(),
+	// which is set before the task is handed over and is only counted once it's first run.
+	resumed bool
 }
 
 // run starts a goroutine to repeat the process
 // that performs the function calls.
 func (w *goWorker) run() {
 	w.pool.addRunning(1)
+	w.pool.addGoroutines(1)
+	atomic.StoreUint64(&w.id, atomic.AddUint64(&w.pool.nextWorkerID, 1))
+	atomic.StoreInt64(&w.spawnedAt, time.Now().UnixNano())
+	atomic.StoreUint64(&w.served, 0)
+	// The worker may be reused from the cache, don't carry its panics over to the new goroutine.
+	w.panics = 0
+	w.pool.live.Store(w, struct{}{})
+	w.pool.emit(WorkerSpawned)
 	go func() {
+		defer w.pool.addGoroutines(-1)
+		if depth := w.pool.options.StackWarm; depth > 0 {
+			warmStack(depth)
+		}
+		// The ID of the goroutine tells pool.Broadcast() whether it's called from a task of this worker.
+		w.clock.register()
+		if w.pool.options.StuckTaskDump > 0 {
+			w.pool.clocks.Store(&w.clock, struct{}{})
+		}
 		defer func() {
+			if w.pool.options.StuckTaskDump > 0 {
+				w.pool.clocks.Delete(&w.clock)
+			}
+			w.pool.live.Delete(w)
+			w.pool.lock.Lock()
+			broadcasts := w.broadcasts
+			w.broadcasts = nil
+			w.pool.lock.Unlock()
+			for _, f := range broadcasts {
+				f()
+			}
 			w.pool.addRunning(-1)
-			w.pool.workerCache.Put(w)
+			if q := w.pool.options.PanicQuarantine; q == 0 || w.panics < q {
+				w.pool.workerCache.Put(w)
+			}
 			if p := recover(); p != nil {
-				if ph := w.pool.options.PanicHandler; ph != nil {
+				if ph := w.pool.hotOptions().panicHandler; ph != nil {
 					ph(p)
 				} else {
-					w.pool.options.Logger.Printf("worker exits from panic: %v\n%s\n", p, debug.Stack())
+					w.pool.hotOptions().logger.Printf("worker exits from panic: %v\n%s\n", p, debug.Stack())
 				}
 			}
 			// Call Signal() here in case there are goroutines waiting for available workers.
 			w.pool.cond.Signal()
+			w.pool.emit(WorkerStopped)
+			notify(w.pool.availability)
+			notify(w.pool.stopped)
+			w.pool.notifySaturation()
+			w.pool.resumeYielded()
 		}()
 
 		for f := range w.task {
 			if f == nil {
 				return
 			}
-			f()
+			if !w.execute(f) || !w.resume() {
+				return
+			}
+			if w.pool.options.NoReuse {
+				return
+			}
 			if ok := w.pool.revertWorker(w); !ok {
 				return
 			}
@@ -72,6 +142,93 @@ func (w *goWorker) run() {
 	}()
 }
 
+// execute performs the function call, it reports false if the worker has to be quarantined
+// since it has recovered from too many panics.
+func (w *goWorker) execute(f func()) bool {
+	if w.probe {
+		w.probe = false
+		f()
+		return true
+	}
+	resumed := w.resumed
+	w.resumed = false
+	atomic.StoreInt32(&w.busy, 1)
+	defer func() {
+		// Clear the tag whichever way the worker goes afterwards, the tag is only written
+		// by the submitter before it hands the task over, so it's safe to read it here.
+		if w.tag != "" {
+			w.pool.lock.Lock()
+			w.tag = ""
+			w.pool.lock.Unlock()
+		}
+		atomic.StoreInt32(&w.busy, 0)
+		if !resumed {
+			atomic.AddUint64(&w.served, 1)
+			w.pool.taskCompleted()
+		}
+	}()
+	if h := w.pool.latency; h != nil || w.pool.options.ExecTimeTracking {
+		start := time.Now()
+		defer func() {
+			d := time.Since(start)
+			atomic.AddUint64(&w.pool.execTime, uint64(d))
+			if h != nil && !resumed {
+				h.observe(d)
+			}
+		}()
+	}
+	if w.pool.options.StuckTaskDump > 0 {
+		w.clock.start()
+		defer w.clock.stop()
+	}
+	if w.pool.options.PanicQuarantine > 0 {
+		return w.executeRecovered(f)
+	}
+	f()
+	return true
+}
+
+// resume runs the rest of the yielded tasks on the worker before it's put back,
+// it reports false if the worker has to be quarantined.
+func (w *goWorker) resume() bool {
+	if atomic.LoadInt32(&w.pool.numYielded) == 0 {
+		return true
+	}
+	for {
+		w.pool.lock.Lock()
+		if w.pool.IsClosed() {
+			w.pool.dropYielded()
+		}
+		rest := w.pool.nextYielded()
+		w.pool.lock.Unlock()
+		if rest == nil {
+			return true
+		}
+		w.resumed = true
+		if !w.execute(rest) {
+			return false
+		}
+	}
+}
+
+// executeRecovered performs the function call and recovers from its panic,
+// it reports false once the number of recovered panics reaches PanicQuarantine.
+func (w *goWorker) executeRecovered(f func()) (healthy bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			if ph := w.pool.hotOptions().panicHandler; ph != nil {
+				ph(p)
+			} else {
+				w.pool.hotOptions().logger.Printf("worker recovers from panic: %v\n%s\n", p, debug.Stack())
+			}
+			w.panics++
+			healthy = w.panics < w.pool.options.PanicQuarantine
+		}
+	}()
+	f()
+	return true
+}
+
 func (w *goWorker) finish() {
 	w.task <- nil
 }
@@ -87,3 +244,14 @@ func (w *goWorker) inputFunc(fn func()) {
 func (w *goWorker) inputParam(interface{}) {
 	panic("unreachable")
 }
+
+// warmStack grows the stack of the calling goroutine by recursing depth frames deep.
+//
+//go:noinline
+func warmStack(depth int) byte {
+	var frame [128]byte
+	if depth > 0 {
+		frame[depth%len(frame)] = warmStack(depth - 1)
+	}
+	return frame[0]
+}
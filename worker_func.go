@@ -24,6 +24,7 @@ package ants
 
 import (
 	"runtime/debug"
+	"sync/atomic"
 	"time"
 )
 
@@ -31,6 +32,10 @@ import (
 // it starts a goroutine that accepts tasks and
 // performs function calls.
 type goWorkerWithFunc struct {
+	// clock records when the current task started for StuckTaskDump, it's placed first
+	// to guarantee the 64-bit alignment required by atomic operations on 32-bit platforms.
+	clock taskClock
+
 	// pool who owns this worker.
 	pool *PoolWithFunc
 
@@ -39,16 +44,35 @@ type goWorkerWithFunc struct {
 
 	// lastUsed will be updated when putting a worker back into queue.
 	lastUsed time.Time
+
+	// panics is the number of panics this worker has recovered from under PanicQuarantine.
+	panics int
 }
 
 // run starts a goroutine to repeat the process
 // that performs the function calls.
 func (w *goWorkerWithFunc) run() {
 	w.pool.addRunning(1)
+	w.pool.addGoroutines(1)
+	// The worker may be reused from the cache, don't carry its panics over to the new goroutine.
+	w.panics = 0
 	go func() {
+		defer w.pool.addGoroutines(-1)
+		if depth := w.pool.options.StackWarm; depth > 0 {
+			warmStack(depth)
+		}
+		if w.pool.options.StuckTaskDump > 0 {
+			w.clock.register()
+			w.pool.clocks.Store(&w.clock, struct{}{})
+		}
 		defer func() {
+			if w.pool.options.StuckTaskDump > 0 {
+				w.pool.clocks.Delete(&w.clock)
+			}
 			w.pool.addRunning(-1)
-			w.pool.workerCache.Put(w)
+			if q := w.pool.options.PanicQuarantine; q == 0 || w.panics < q {
+				w.pool.workerCache.Put(w)
+			}
 			if p := recover(); p != nil {
 				if ph := w.pool.options.PanicHandler; ph != nil {
 					ph(p)
@@ -64,7 +88,12 @@ func (w *goWorkerWithFunc) run() {
 			if args == nil {
 				return
 			}
-			w.pool.poolFunc(args)
+			if !w.execute(args) {
+				return
+			}
+			if w.pool.options.NoReuse {
+				return
+			}
 			if ok := w.pool.revertWorker(w); !ok {
 				return
 			}
@@ -72,6 +101,48 @@ func (w *goWorkerWithFunc) run() {
 	}()
 }
 
+// execute performs the function call, it reports false if the worker has to be quarantined
+// since it has recovered from too many panics.
+func (w *goWorkerWithFunc) execute(args interface{}) bool {
+	if h := w.pool.latency; h != nil || w.pool.options.ExecTimeTracking {
+		start := time.Now()
+		defer func() {
+			d := time.Since(start)
+			atomic.AddUint64(&w.pool.execTime, uint64(d))
+			if h != nil {
+				h.observe(d)
+			}
+		}()
+	}
+	if w.pool.options.StuckTaskDump > 0 {
+		w.clock.start()
+		defer w.clock.stop()
+	}
+	if w.pool.options.PanicQuarantine > 0 {
+		return w.executeRecovered(args)
+	}
+	w.pool.poolFunc(args)
+	return true
+}
+
+// executeRecovered performs the function call and recovers from its panic,
+// it reports false once the number of recovered panics reaches PanicQuarantine.
+func (w *goWorkerWithFunc) executeRecovered(args interface{}) (healthy bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			if ph := w.pool.options.PanicHandler; ph != nil {
+				ph(p)
+			} else {
+				w.pool.options.Logger.Printf("worker recovers from panic: %v\n%s\n", p, debug.Stack())
+			}
+			w.panics++
+			healthy = w.panics < w.pool.options.PanicQuarantine
+		}
+	}()
+	w.pool.poolFunc(args)
+	return true
+}
+
 func (w *goWorkerWithFunc) finish() {
 	w.args <- nil
 }
@@ -8,6 +8,9 @@ type workerStack struct {
 }
 
 func newWorkerStack(size int) *workerStack {
+	if size < 0 {
+		size = 0
+	}
 	return &workerStack{
 		items: make([]worker, 0, size),
 	}
@@ -87,3 +87,17 @@ func TestSearch(t *testing.T) {
 
 	assert.EqualValues(t, 7, q.binarySearch(0, q.len()-1, expiry3), "index should be 7")
 }
+
+func TestWorkerStackInitialCap(t *testing.T) {
+	size := 100
+	q := newWorkerStack(size)
+	for i := 0; i < size; i++ {
+		_ = q.insert(&goWorker{lastUsed: time.Now()})
+	}
+	assert.EqualValues(t, size, q.len(), "Len error")
+	assert.EqualValues(t, size, cap(q.items), "pre-sized stack should not be reallocated")
+
+	p, _ := NewPool(size, WithInitialWorkerCap(size))
+	defer p.Release()
+	assert.EqualValues(t, size, cap(p.workers.(*workerStack).items), "worker queue should be pre-sized")
+}